@@ -3,9 +3,11 @@ package core
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // FileAction represents the type of action to perform on a file.
@@ -54,10 +56,27 @@ func (f *FileActionTask) String() string {
 
 // ExecuteFileActionTask executes a file action task.
 func ExecuteFileActionTask(storage Storage, root *os.Root, task *FileActionTask) error {
+	return executeFileActionTask(storage, root, task, ".")
+}
+
+// executeFileActionTask executes a file action task whose storage paths are below
+// the folder prefix, which corresponds to root on the file system.
+func executeFileActionTask(storage Storage, root *os.Root, task *FileActionTask, prefix string) error {
+	rootPath := func(path string) string {
+		if prefix == "." {
+			return path
+		}
+		relPath, err := filepath.Rel(prefix, path)
+		if err != nil {
+			return path
+		}
+		return relPath
+	}
+
 	switch task.Action {
 	case Move:
 		exists := false
-		if f, err := root.Open(filepath.Join(task.TargetFolder.Path, task.TargetName)); err == nil {
+		if f, err := root.Open(rootPath(filepath.Join(task.TargetFolder.Path, task.TargetName))); err == nil {
 			f.Close()
 			exists = true
 		}
@@ -66,10 +85,12 @@ func ExecuteFileActionTask(storage Storage, root *os.Root, task *FileActionTask)
 			targetName = task.File.Name
 		}
 
-		err := root.Rename(task.File.Path, filepath.Join(task.TargetFolder.Path, targetName))
+		sourceFolder := task.File.Parent
+		err := root.Rename(rootPath(task.File.Path), rootPath(filepath.Join(task.TargetFolder.Path, targetName)))
 		if err != nil {
 			return err
 		}
+		restoreFolderTimes(root, rootPath, sourceFolder, task.TargetFolder)
 
 		err = storage.RemoveFile(task.File)
 		if err != nil {
@@ -83,11 +104,14 @@ func ExecuteFileActionTask(storage Storage, root *os.Root, task *FileActionTask)
 				Size:    task.File.Size,
 				ModTime: task.File.ModTime,
 				Name:    targetName,
+				Tags:    task.File.Tags,
+				Device:  task.File.Device,
+				Inode:   task.File.Inode,
 			})
 		}
 		return nil
 	case Delete:
-		err := root.Remove(task.File.Path)
+		err := root.Remove(rootPath(task.File.Path))
 		if err != nil {
 			return err
 		}
@@ -99,40 +123,61 @@ func ExecuteFileActionTask(storage Storage, root *os.Root, task *FileActionTask)
 		targetPath := filepath.Join(task.TargetFolder.Path, task.Folder.Name)
 
 		// if target folder already exists, return error
-		if _, err := root.Stat(targetPath); err == nil {
+		if _, err := root.Stat(rootPath(targetPath)); err == nil {
 			return fmt.Errorf("target folder %s already exists", targetPath)
 		}
 
-		err := root.Rename(task.Folder.Path, targetPath)
+		sourceFolder := task.Folder.Parent
+		err := root.Rename(rootPath(task.Folder.Path), rootPath(targetPath))
 		if err != nil {
 			return err
 		}
 
-		// TODO: remove folder from storage
-
+		if err := storage.MoveFolder(task.Folder.Path, task.TargetFolder.Path); err != nil {
+			return err
+		}
+		restoreFolderTimes(root, rootPath, sourceFolder, task.TargetFolder, task.Folder)
 		return nil
 	case DeleteFolder:
 		if task.Folder == nil {
 			return fmt.Errorf("folder is nil")
 		}
-		err := root.Remove(task.Folder.Path)
+		err := root.Remove(rootPath(task.Folder.Path))
 		if err != nil {
 			return err
 		}
 
-		// TODO: remove folder from storage
-
-		return nil
+		return storage.RemoveFolder(task.Folder.Path)
 	case DeleteEmptyFolder:
 		if task.Folder == nil {
 			return fmt.Errorf("folder is nil")
 		}
-		return RemoveEmptyFolder(root, task.Folder.Path)
+		err := RemoveEmptyFolder(root, rootPath(task.Folder.Path))
+		if errors.Is(err, fs.ErrNotExist) {
+			// already removed by a previous task
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		return storage.RemoveFolder(task.Folder.Path)
 	default:
 		return nil
 	}
 }
 
+// restoreFolderTimes sets the modification time of the folders back to the
+// scanned one, which moving an entry in or out of a directory changes.
+func restoreFolderTimes(root *os.Root, rootPath func(path string) string, folders ...*Folder) {
+	for _, folder := range folders {
+		if folder == nil || folder.Info == nil {
+			continue
+		}
+		// best effort, the move itself succeeded
+		root.Chtimes(rootPath(folder.Path), time.Time{}, folder.Info.ModTime)
+	}
+}
+
 func RemoveEmptyFolder(root *os.Root, path string) error {
 	dir, err := root.Open(path)
 	if err != nil {
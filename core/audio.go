@@ -0,0 +1,390 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/kalafut/imohash"
+)
+
+// Hasher names accepted by Scanner.Hasher.
+const (
+	// HasherImohash hashes the whole file content with imohash.
+	HasherImohash = "imohash"
+	// HasherAudio hashes only the audio payload of MP3 and FLAC files,
+	// ignoring ID3/APE tags and FLAC metadata blocks. Other files fall back to imohash.
+	HasherAudio = "audio"
+	// HasherSHA256 hashes the whole file content with SHA-256, compatible with sha256sum manifests.
+	HasherSHA256 = "sha256"
+	// HasherMD5 hashes the whole file content with MD5, compatible with md5sum manifests.
+	HasherMD5 = "md5"
+)
+
+// id3v2Names maps ID3v2 text frame ids to the Vorbis comment names,
+// so MP3 and FLAC tags can be compared with each other.
+var id3v2Names = map[string]string{
+	"TIT2": "title",
+	"TPE1": "artist",
+	"TPE2": "albumartist",
+	"TALB": "album",
+	"TRCK": "tracknumber",
+	"TPOS": "discnumber",
+	"TYER": "date",
+	"TDRC": "date",
+	"TCON": "genre",
+	"TCOM": "composer",
+	"TT2":  "title",
+	"TP1":  "artist",
+	"TP2":  "albumartist",
+	"TAL":  "album",
+	"TRK":  "tracknumber",
+	"TPA":  "discnumber",
+	"TYE":  "date",
+	"TCO":  "genre",
+	"TCM":  "composer",
+}
+
+// TagDifference represents a tag whose value differs between two files.
+type TagDifference struct {
+	Name   string
+	Value1 string
+	Value2 string
+}
+
+// TagDiff returns the tags which differ between file1 and file2, sorted by name.
+func TagDiff(file1, file2 *File) []TagDifference {
+	diff := []TagDifference{}
+	if file1 == nil || file2 == nil {
+		return diff
+	}
+
+	for name, value := range file1.Tags {
+		if file2.Tags[name] != value {
+			diff = append(diff, TagDifference{Name: name, Value1: value, Value2: file2.Tags[name]})
+		}
+	}
+	for name, value := range file2.Tags {
+		if _, ok := file1.Tags[name]; !ok {
+			diff = append(diff, TagDifference{Name: name, Value2: value})
+		}
+	}
+
+	sort.Slice(diff, func(i, j int) bool {
+		return diff[i].Name < diff[j].Name
+	})
+	return diff
+}
+
+// HasTagDifferences reports whether the files in this group share the same
+// audio payload but carry different tags.
+func (g *MatchedFileGroup) HasTagDifferences() bool {
+	for i := 1; i < len(g.Files); i++ {
+		if len(TagDiff(g.Files[0], g.Files[i])) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isAudioFile reports whether the file name has an extension supported by the audio hasher.
+func isAudioFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mp3", ".flac":
+		return true
+	}
+	return false
+}
+
+// getAudioHash computes a hash of the audio payload of an MP3 or FLAC file and
+// returns the tags found in the stripped metadata.
+// Files which are not recognised as audio are hashed as a whole.
+func getAudioHash(file fs.File, hash imohash.ImoHash) (string, map[string]string, error) {
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		return "", nil, fmt.Errorf("file does not implement io.ReaderAt")
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get file size: %w", err)
+	}
+
+	if !isAudioFile(fi.Name()) {
+		h, err := getFileHash(file, hash)
+		return h, nil, err
+	}
+
+	offset, length, tags, err := audioPayload(readerAt, fi.Size())
+	if err != nil {
+		// not a well-formed audio file, hash the whole content instead
+		h, err := getFileHash(file, hash)
+		return h, nil, err
+	}
+
+	hashValue, err := hash.SumSectionReader(io.NewSectionReader(readerAt, offset, length))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	return base64.RawStdEncoding.EncodeToString(hashValue[:]), tags, nil
+}
+
+// audioPayload locates the audio data of an MP3 or FLAC stream, skipping leading
+// ID3v2 tags, FLAC metadata blocks and trailing APEv2/ID3v1 tags.
+func audioPayload(r io.ReaderAt, size int64) (offset int64, length int64, tags map[string]string, err error) {
+	tags = map[string]string{}
+	end := size
+
+	// leading ID3v2 tags, possibly more than one
+	header := make([]byte, 10)
+	for {
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return 0, 0, nil, err
+		}
+		if string(header[:3]) != "ID3" {
+			break
+		}
+		tagSize := int64(syncsafe(header[6:10])) + 10
+		if header[5]&0x10 != 0 {
+			// footer present
+			tagSize += 10
+		}
+		if offset+tagSize > size {
+			return 0, 0, nil, fmt.Errorf("truncated ID3v2 tag")
+		}
+		body := make([]byte, tagSize-10)
+		if _, err := r.ReadAt(body, offset+10); err != nil {
+			return 0, 0, nil, err
+		}
+		parseID3v2(header, body, tags)
+		offset += tagSize
+	}
+
+	// FLAC metadata blocks
+	if string(header[:4]) == "fLaC" {
+		offset += 4
+		blockHeader := make([]byte, 4)
+		for {
+			if _, err := r.ReadAt(blockHeader, offset); err != nil {
+				return 0, 0, nil, err
+			}
+			blockSize := int64(blockHeader[1])<<16 | int64(blockHeader[2])<<8 | int64(blockHeader[3])
+			if offset+4+blockSize > size {
+				return 0, 0, nil, fmt.Errorf("truncated FLAC metadata block")
+			}
+			if blockHeader[0]&0x7f == 4 {
+				body := make([]byte, blockSize)
+				if _, err := r.ReadAt(body, offset+4); err != nil {
+					return 0, 0, nil, err
+				}
+				parseVorbisComment(body, tags)
+			}
+			offset += 4 + blockSize
+			if blockHeader[0]&0x80 != 0 {
+				break
+			}
+		}
+	}
+
+	// trailing ID3v1 tag
+	if end-offset >= 128 {
+		trailer := make([]byte, 128)
+		if _, err := r.ReadAt(trailer, end-128); err != nil {
+			return 0, 0, nil, err
+		}
+		if string(trailer[:3]) == "TAG" {
+			parseID3v1(trailer, tags)
+			end -= 128
+		}
+	}
+
+	// trailing APEv2 tag
+	if end-offset >= 32 {
+		footer := make([]byte, 32)
+		if _, err := r.ReadAt(footer, end-32); err != nil {
+			return 0, 0, nil, err
+		}
+		if string(footer[:8]) == "APETAGEX" {
+			tagSize := int64(binary.LittleEndian.Uint32(footer[12:16]))
+			if binary.LittleEndian.Uint32(footer[20:24])&(1<<31) != 0 {
+				// header present
+				tagSize += 32
+			}
+			if tagSize <= end-offset {
+				end -= tagSize
+			}
+		}
+	}
+
+	if end <= offset {
+		return 0, 0, nil, fmt.Errorf("no audio payload")
+	}
+	return offset, end - offset, tags, nil
+}
+
+// syncsafe decodes a 28 bit ID3v2 syncsafe integer.
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7f)<<21 | uint32(b[1]&0x7f)<<14 | uint32(b[2]&0x7f)<<7 | uint32(b[3]&0x7f)
+}
+
+// parseID3v2 extracts the text frames of an ID3v2 tag into tags.
+func parseID3v2(header []byte, body []byte, tags map[string]string) {
+	version := header[3]
+	flags := header[5]
+
+	// skip extended header
+	if flags&0x40 != 0 && len(body) >= 4 {
+		switch version {
+		case 3:
+			body = body[min(len(body), int(binary.BigEndian.Uint32(body[:4]))+4):]
+		case 4:
+			body = body[min(len(body), int(syncsafe(body[:4]))):]
+		}
+	}
+
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	for len(body) >= headerLen && body[0] != 0 {
+		id := string(body[:idLen])
+		var frameSize int
+		switch version {
+		case 2:
+			frameSize = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 4:
+			frameSize = int(syncsafe(body[4:8]))
+		default:
+			frameSize = int(binary.BigEndian.Uint32(body[4:8]))
+		}
+		if frameSize <= 0 || headerLen+frameSize > len(body) {
+			return
+		}
+		frame := body[headerLen : headerLen+frameSize]
+		body = body[headerLen+frameSize:]
+
+		if id[0] != 'T' || id == "TXXX" || id == "TXX" {
+			continue
+		}
+		name, ok := id3v2Names[id]
+		if !ok {
+			name = strings.ToLower(id)
+		}
+		if value := decodeID3Text(frame); value != "" {
+			tags[name] = value
+		}
+	}
+}
+
+// decodeID3Text decodes the content of an ID3v2 text frame.
+func decodeID3Text(frame []byte) string {
+	if len(frame) < 1 {
+		return ""
+	}
+	encoding, data := frame[0], frame[1:]
+
+	var value string
+	switch encoding {
+	case 1, 2:
+		bigEndian := encoding == 2
+		if len(data) >= 2 && data[0] == 0xff && data[1] == 0xfe {
+			bigEndian, data = false, data[2:]
+		} else if len(data) >= 2 && data[0] == 0xfe && data[1] == 0xff {
+			bigEndian, data = true, data[2:]
+		}
+		units := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			if bigEndian {
+				units = append(units, binary.BigEndian.Uint16(data[i:]))
+			} else {
+				units = append(units, binary.LittleEndian.Uint16(data[i:]))
+			}
+		}
+		value = string(utf16.Decode(units))
+	case 3:
+		value = string(data)
+	default:
+		value = latin1(data)
+	}
+
+	// multiple values are separated by null characters
+	return strings.Join(strings.FieldsFunc(value, func(r rune) bool { return r == 0 }), "; ")
+}
+
+// parseID3v1 extracts the fields of an ID3v1 tag into tags,
+// keeping any value already read from an ID3v2 tag.
+func parseID3v1(trailer []byte, tags map[string]string) {
+	fields := []struct {
+		name       string
+		start, end int
+	}{
+		{"title", 3, 33},
+		{"artist", 33, 63},
+		{"album", 63, 93},
+		{"date", 93, 97},
+	}
+	for _, field := range fields {
+		if _, ok := tags[field.name]; ok {
+			continue
+		}
+		value := strings.TrimSpace(latin1(bytes.TrimRight(trailer[field.start:field.end], "\x00")))
+		if value != "" {
+			tags[field.name] = value
+		}
+	}
+	// ID3v1.1 track number
+	if _, ok := tags["tracknumber"]; !ok && trailer[125] == 0 && trailer[126] != 0 {
+		tags["tracknumber"] = fmt.Sprintf("%d", trailer[126])
+	}
+}
+
+// parseVorbisComment extracts the comments of a FLAC VORBIS_COMMENT block into tags.
+func parseVorbisComment(body []byte, tags map[string]string) {
+	if len(body) < 4 {
+		return
+	}
+	vendorLen := int(binary.LittleEndian.Uint32(body[:4]))
+	if 4+vendorLen+4 > len(body) {
+		return
+	}
+	body = body[4+vendorLen:]
+	count := int(binary.LittleEndian.Uint32(body[:4]))
+	body = body[4:]
+
+	for i := 0; i < count && len(body) >= 4; i++ {
+		commentLen := int(binary.LittleEndian.Uint32(body[:4]))
+		if 4+commentLen > len(body) {
+			return
+		}
+		comment := string(body[4 : 4+commentLen])
+		body = body[4+commentLen:]
+
+		name, value, ok := strings.Cut(comment, "=")
+		if !ok || value == "" {
+			continue
+		}
+		name = strings.ToLower(name)
+		if existing, ok := tags[name]; ok {
+			value = existing + "; " + value
+		}
+		tags[name] = value
+	}
+}
+
+// latin1 decodes ISO-8859-1 bytes into a string.
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
@@ -0,0 +1,264 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kalafut/imohash"
+)
+
+// audioFrames returns fake MPEG frames, different for every seed.
+func audioFrames(seed byte, length int) []byte {
+	frames := make([]byte, length)
+	for i := range frames {
+		frames[i] = byte(i)*7 + seed
+	}
+	frames[0], frames[1] = 0xff, 0xfb
+	return frames
+}
+
+// encodeSyncsafe encodes a 28 bit ID3v2 syncsafe integer.
+func encodeSyncsafe(v int) []byte {
+	return []byte{byte(v >> 21 & 0x7f), byte(v >> 14 & 0x7f), byte(v >> 7 & 0x7f), byte(v & 0x7f)}
+}
+
+// id3v2Tag builds an ID3v2 tag of the given major version holding the frames.
+func id3v2Tag(version byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	tag := []byte{'I', 'D', '3', version, 0, 0}
+	tag = append(tag, encodeSyncsafe(len(body))...)
+	return append(tag, body...)
+}
+
+// id3v2Frame builds a frame of an ID3v2 tag of the given major version with an encoding byte and text.
+func id3v2Frame(version byte, id string, encoding byte, text []byte) []byte {
+	data := append([]byte{encoding}, text...)
+	frame := []byte(id)
+	switch version {
+	case 2:
+		frame = append(frame, byte(len(data)>>16), byte(len(data)>>8), byte(len(data)))
+	case 4:
+		frame = append(frame, encodeSyncsafe(len(data))...)
+		frame = append(frame, 0, 0)
+	default:
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(data)))
+		frame = append(frame, 0, 0)
+	}
+	return append(frame, data...)
+}
+
+// id3v1Tag builds an ID3v1.1 tag.
+func id3v1Tag(title string, artist string, track byte) []byte {
+	tag := make([]byte, 128)
+	copy(tag, "TAG")
+	copy(tag[3:33], title)
+	copy(tag[33:63], artist)
+	copy(tag[93:97], "1999")
+	tag[126] = track
+	return tag
+}
+
+// apeTag builds an APEv2 tag with a footer only.
+func apeTag(items []byte) []byte {
+	footer := make([]byte, 32)
+	copy(footer, "APETAGEX")
+	binary.LittleEndian.PutUint32(footer[8:], 2000)
+	binary.LittleEndian.PutUint32(footer[12:], uint32(len(items)+32))
+	return append(append([]byte{}, items...), footer...)
+}
+
+// flacStream builds a FLAC stream with a STREAMINFO block, a VORBIS_COMMENT block with the comments and the frames.
+func flacStream(frames []byte, comments ...string) []byte {
+	comment := binary.LittleEndian.AppendUint32(nil, 6)
+	comment = append(comment, "vendor"...)
+	comment = binary.LittleEndian.AppendUint32(comment, uint32(len(comments)))
+	for _, c := range comments {
+		comment = binary.LittleEndian.AppendUint32(comment, uint32(len(c)))
+		comment = append(comment, c...)
+	}
+
+	stream := []byte("fLaC")
+	stream = append(stream, 0, 0, 0, 34)
+	stream = append(stream, make([]byte, 34)...)
+	stream = append(stream, 0x84, byte(len(comment)>>16), byte(len(comment)>>8), byte(len(comment)))
+	stream = append(stream, comment...)
+	return append(stream, frames...)
+}
+
+// hashContent writes the content to a file with the given name and hashes it with the hasher.
+func hashContent(t *testing.T, name string, content []byte, hasher string) (string, map[string]string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	hash, tags, err := hashFile(file, hasher, imohash.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash, tags
+}
+
+func TestAudioHashIgnoresTags(t *testing.T) {
+	frames := audioFrames(1, 4000)
+	plain, _ := hashContent(t, "plain.mp3", frames, HasherAudio)
+	utf16 := []byte{0xff, 0xfe, 'S', 0, 'o', 0, 'n', 0, 'g', 0}
+
+	tests := []struct {
+		name    string
+		file    string
+		content []byte
+		tags    map[string]string
+	}{
+		{
+			name:    "ID3v2.3",
+			file:    "a.mp3",
+			content: bytes.Join([][]byte{id3v2Tag(3, id3v2Frame(3, "TIT2", 0, []byte("Song")), id3v2Frame(3, "TXXX", 0, []byte("x"))), frames}, nil),
+			tags:    map[string]string{"title": "Song"},
+		},
+		{
+			name:    "ID3v2.4 UTF-16 and ID3v1",
+			file:    "b.mp3",
+			content: bytes.Join([][]byte{id3v2Tag(4, id3v2Frame(4, "TIT2", 1, utf16), id3v2Frame(4, "TPE1", 3, []byte("A\x00B"))), frames, id3v1Tag("Other", "Artist", 5)}, nil),
+			tags:    map[string]string{"title": "Song", "artist": "A; B", "date": "1999", "tracknumber": "5"},
+		},
+		{
+			name:    "ID3v2.2 and APEv2",
+			file:    "c.mp3",
+			content: bytes.Join([][]byte{id3v2Tag(2, id3v2Frame(2, "TAL", 0, []byte("Alb\xe9"))), frames, apeTag([]byte("some items"))}, nil),
+			tags:    map[string]string{"album": "Albé"},
+		},
+		{
+			name:    "two ID3v2 tags, APEv2 and ID3v1",
+			file:    "d.mp3",
+			content: bytes.Join([][]byte{id3v2Tag(3, id3v2Frame(3, "TCON", 0, []byte("Rock"))), id3v2Tag(3), frames, apeTag(nil), id3v1Tag("T", "", 0)}, nil),
+			tags:    map[string]string{"genre": "Rock", "title": "T", "date": "1999"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hash, tags := hashContent(t, test.file, test.content, HasherAudio)
+			if hash != plain {
+				t.Errorf("tagged copy hashes %s, want the hash of the audio %s", hash, plain)
+			}
+			if len(tags) != len(test.tags) {
+				t.Errorf("tags %v, want %v", tags, test.tags)
+			}
+			for name, value := range test.tags {
+				if tags[name] != value {
+					t.Errorf("tag %s is %q, want %q", name, tags[name], value)
+				}
+			}
+		})
+	}
+
+	// whole content hashes differ when only the tags differ
+	whole1, _ := hashContent(t, "a.mp3", tests[0].content, HasherImohash)
+	whole2, _ := hashContent(t, "b.mp3", tests[1].content, HasherImohash)
+	if whole1 == whole2 {
+		t.Errorf("whole content hashes of differently tagged copies are equal")
+	}
+}
+
+func TestAudioHashFLAC(t *testing.T) {
+	frames := audioFrames(2, 4000)
+	hash1, tags1 := hashContent(t, "a.flac", flacStream(frames, "TITLE=Song", "ARTIST=A", "ARTIST=B"), HasherAudio)
+	hash2, tags2 := hashContent(t, "b.flac", flacStream(frames, "TITLE=Other song"), HasherAudio)
+	hash3, _ := hashContent(t, "c.flac", flacStream(audioFrames(3, 4000), "TITLE=Song"), HasherAudio)
+
+	if hash1 != hash2 {
+		t.Errorf("FLAC copies with different comments hash %s and %s", hash1, hash2)
+	}
+	if hash1 == hash3 {
+		t.Errorf("FLAC streams with different audio hash equal")
+	}
+	if tags1["title"] != "Song" || tags1["artist"] != "A; B" || tags2["title"] != "Other song" {
+		t.Errorf("FLAC tags %v and %v", tags1, tags2)
+	}
+	if len(TagDiff(&File{Tags: tags1}, &File{Tags: tags2})) != 2 {
+		t.Errorf("tag differences %v", TagDiff(&File{Tags: tags1}, &File{Tags: tags2}))
+	}
+}
+
+func TestAudioHashDifferentAudio(t *testing.T) {
+	tag := id3v2Tag(3, id3v2Frame(3, "TIT2", 0, []byte("Song")))
+	hash1, _ := hashContent(t, "a.mp3", append(append([]byte{}, tag...), audioFrames(1, 4000)...), HasherAudio)
+	hash2, _ := hashContent(t, "b.mp3", append(append([]byte{}, tag...), audioFrames(4, 4000)...), HasherAudio)
+	if hash1 == hash2 {
+		t.Errorf("different audio with the same tags hashes equal")
+	}
+}
+
+func TestAudioHashMalformed(t *testing.T) {
+	frames := audioFrames(5, 4000)
+	plain, _ := hashContent(t, "plain.mp3", frames, HasherAudio)
+	flacPlain, _ := hashContent(t, "plain.flac", flacStream(frames), HasherAudio)
+
+	truncatedID3 := id3v2Tag(3, id3v2Frame(3, "TIT2", 0, []byte("Song")))
+	copy(truncatedID3[6:10], encodeSyncsafe(100000))
+	badFrame := id3v2Frame(3, "TIT2", 0, []byte("Song"))
+	binary.BigEndian.PutUint32(badFrame[4:8], 1<<31)
+	extended := id3v2Tag(4, id3v2Frame(4, "TIT2", 0, []byte("Song")))
+	extended[5] = 0x40
+	badComment := flacStream(frames, "TITLE=Song")
+	// the comment count claims more comments than the block holds
+	binary.LittleEndian.PutUint32(badComment[8+34+4+4+6:], 1000)
+	truncatedFLAC := []byte("fLaC\x00\xff\xff\xff")
+
+	// malformed tags whose size is known still locate the audio
+	located := []struct {
+		name    string
+		file    string
+		content []byte
+		want    string
+	}{
+		{"frame longer than the tag", "a.mp3", append(id3v2Tag(3, badFrame), frames...), plain},
+		{"extended header longer than the tag", "b.mp3", append(extended, frames...), plain},
+		{"Vorbis comment count", "c.flac", badComment, flacPlain},
+		{"frame without text", "d.mp3", append(id3v2Tag(3, []byte("TIT2\x00\x00\x00\x00\x00\x00")), frames...), plain},
+	}
+	for _, test := range located {
+		t.Run(test.name, func(t *testing.T) {
+			if hash, _ := hashContent(t, test.file, test.content, HasherAudio); hash != test.want {
+				t.Errorf("hash %s, want the hash of the audio %s", hash, test.want)
+			}
+		})
+	}
+
+	// truncated tags fall back to the hash of the whole file
+	whole := []struct {
+		name    string
+		file    string
+		content []byte
+	}{
+		{"truncated ID3v2 tag", "e.mp3", append(truncatedID3, frames[:100]...)},
+		{"truncated FLAC block", "f.flac", append(truncatedFLAC, frames[:100]...)},
+		{"tag without audio", "g.mp3", id3v2Tag(3, id3v2Frame(3, "TIT2", 0, []byte("Song")))},
+		{"shorter than a tag header", "h.mp3", []byte("ID3")},
+		{"empty", "i.flac", nil},
+	}
+	for _, test := range whole {
+		t.Run(test.name, func(t *testing.T) {
+			hash, tags := hashContent(t, test.file, test.content, HasherAudio)
+			want, _ := hashContent(t, test.file, test.content, HasherImohash)
+			if hash != want || tags != nil {
+				t.Errorf("hash %s with tags %v, want the whole file hash %s", hash, tags, want)
+			}
+		})
+	}
+
+	// other files are hashed as a whole
+	tagged := append(id3v2Tag(3, id3v2Frame(3, "TIT2", 0, []byte("Song"))), frames...)
+	hash, _ := hashContent(t, "a.wav", tagged, HasherAudio)
+	if want, _ := hashContent(t, "a.wav", tagged, HasherImohash); hash != want {
+		t.Errorf("file which is not audio hashes %s, want the whole file hash %s", hash, want)
+	}
+}
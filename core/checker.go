@@ -9,15 +9,23 @@ import (
 	"slices"
 	"sort"
 	"strings"
+	"sync"
 )
 
 // FolderSimilarity represents a folder with similarity analysis data.
 type FolderSimilarity struct {
 	*Folder
 	FileCount          int
+	TotalSize          int64
 	DuplicateFileCount int
+	DuplicateSize      int64
 	TargetFolder       *FolderSimilarity
 	DuplicateFiles     map[string]*File
+	// path is the path of the folder when the pair was indexed.
+	path string
+	// matches counts the matching files in the target folder of every duplicate file,
+	// for the pairs of folders holding the files.
+	matches map[*File]int
 }
 
 // DuplicatedPercentage returns the percentage of duplicate files in this folder.
@@ -25,6 +33,69 @@ func (f *FolderSimilarity) DuplicatedPercentage() float64 {
 	return float64(f.DuplicateFileCount) * 100.0 / float64(f.FileCount)
 }
 
+// DuplicatedSizePercentage returns the percentage of duplicate bytes in this folder.
+func (f *FolderSimilarity) DuplicatedSizePercentage() float64 {
+	if f.TotalSize == 0 {
+		return 0
+	}
+	return float64(f.DuplicateSize) * 100.0 / float64(f.TotalSize)
+}
+
+// Similarity returns the percentage of this folder duplicated, as measured by metric.
+func (f *FolderSimilarity) Similarity(metric SimilarityMetric) float64 {
+	switch metric {
+	case MetricBytes:
+		return f.DuplicatedSizePercentage()
+	case MetricBoth:
+		return min(f.DuplicatedPercentage(), f.DuplicatedSizePercentage())
+	}
+	return f.DuplicatedPercentage()
+}
+
+// Coverage describes the duplicate files and bytes of this folder counted by metric.
+func (f *FolderSimilarity) Coverage(metric SimilarityMetric) string {
+	count := fmt.Sprintf("%d/%d %.1f%%", f.DuplicateFileCount, f.FileCount, f.DuplicatedPercentage())
+	size := fmt.Sprintf("%s/%s %.1f%%", FormatFileSize(f.DuplicateSize), FormatFileSize(f.TotalSize), f.DuplicatedSizePercentage())
+	switch metric {
+	case MetricBytes:
+		return size
+	case MetricBoth:
+		return count + ", " + size
+	}
+	return count
+}
+
+// SimilarityMetric selects how the similarity of a folder is measured.
+type SimilarityMetric int
+
+const (
+	// MetricCount measures the share of duplicate files.
+	MetricCount SimilarityMetric = iota
+	// MetricBytes measures the share of duplicate bytes, so large unique files count more than small duplicates.
+	MetricBytes
+	// MetricBoth measures the lower of both shares, so a folder is only similar when it is by count and by bytes.
+	MetricBoth
+)
+
+var similarityMetricNames = []string{"count", "bytes", "both"}
+
+func (m SimilarityMetric) String() string {
+	if int(m) < 0 || int(m) >= len(similarityMetricNames) {
+		return fmt.Sprintf("SimilarityMetric(%d)", int(m))
+	}
+	return similarityMetricNames[m]
+}
+
+// ParseSimilarityMetric returns the metric named count, bytes or both.
+func ParseSimilarityMetric(name string) (SimilarityMetric, error) {
+	for i, metricName := range similarityMetricNames {
+		if name == metricName {
+			return SimilarityMetric(i), nil
+		}
+	}
+	return MetricCount, fmt.Errorf("unknown similarity metric %s", name)
+}
+
 // --- Helper function for similarity checker ---
 
 func folderPairKey(path1 string, path2 string) string {
@@ -43,12 +114,16 @@ func getDuplicatedFolderPair(folder1 *Folder, folder2 *Folder, folders map[strin
 			{
 				Folder:         folder1,
 				FileCount:      folder1.GetFileCount(),
+				TotalSize:      folder1.GetFileSize(),
 				DuplicateFiles: make(map[string]*File),
+				path:           folder1.Path,
 			},
 			{
 				Folder:         folder2,
 				FileCount:      folder2.GetFileCount(),
+				TotalSize:      folder2.GetFileSize(),
 				DuplicateFiles: make(map[string]*File),
+				path:           folder2.Path,
 			},
 		}
 		pair[0].TargetFolder = pair[1]
@@ -57,7 +132,7 @@ func getDuplicatedFolderPair(folder1 *Folder, folder2 *Folder, folders map[strin
 		folders[key] = pair
 	}
 
-	if pair[0].Folder.Path == folder1.Path {
+	if pair[0].path == folder1.Path {
 		return pair[0], pair[1]
 	}
 	return pair[1], pair[0]
@@ -91,9 +166,11 @@ func calculateParentFolderSimilarity(
 			f1, f2 := getDuplicatedFolderPair(currentFolder1, currentFolder2, folders)
 			if f1 != folder1 {
 				f1.DuplicateFileCount += folder1.DuplicateFileCount
+				f1.DuplicateSize += folder1.DuplicateSize
 			}
 			if f2 != folder2 {
 				f2.DuplicateFileCount += folder2.DuplicateFileCount
+				f2.DuplicateSize += folder2.DuplicateSize
 			}
 
 			currentFolder2 = currentFolder2.Parent
@@ -109,7 +186,7 @@ func getFolderSimilarity(path1, path2 string, folders map[string][2]*FolderSimil
 	if !ok {
 		return nil, nil, fmt.Errorf("folder pair not found")
 	}
-	if pair[0].Folder.Path == path1 {
+	if pair[0].path == path1 {
 		return pair[0], pair[1], nil
 	}
 	return pair[1], pair[0], nil
@@ -166,78 +243,117 @@ func GetMatchedFilePairs(folder1, folder2 *FolderSimilarity) (matchedPairs [][2]
 // --- Helper end ---
 
 // SimilarityChecker analyzes folders and files to find duplicates and calculate similarity percentages.
+// After CalculateSimilarity it collects the changes of the storage, which
+// ApplyChanges applies to its indexes without computing everything again.
 type SimilarityChecker struct {
+	// Metric measures the similarity used to sort and filter the folder pairs.
+	Metric SimilarityMetric
+	// Threshold selects the folder pairs returned by GetSimilarityFolderGroup,
+	// GetSimilarityFolderPairs and ContainsSimilarityGroup.
+	Threshold SimilarityThreshold
+	// MaxFanOut is the number of folders holding a hash above which its files are too common
+	// to pair the folders, like an icon in every application. Zero uses DefaultMaxFanOut and
+	// a negative value pairs the folders of every hash. A change applies from CalculateSimilarity.
+	MaxFanOut int
+
 	similarityFolderPairs map[string][2]*FolderSimilarity
 	similarityFolderMap   map[string][]string
+
+	storage Storage
+	// files holds the parent path of every indexed file, as counted in the pairs.
+	files map[*File]string
+	// groups holds the indexed files by hash, only hashes of two or more files are indexed.
+	groups      map[string][]*File
+	unsubscribe func()
+	// common holds the hashes held in more than MaxFanOut folders, and commonFiles their files by folder path.
+	common      map[string]bool
+	commonFiles map[string][]*File
+	// shared counts the matching files of the other hashes in every pair of distinct folders.
+	shared map[string]int
+
+	// relations, redundant and containsRedundant hold the results of GetFolderRelation, IsRedundant
+	// and ContainsRedundantFolder until the storage changes, and hashes the sets of folderHashes.
+	relations         map[[2]string]FolderRelation
+	redundant         map[string]bool
+	containsRedundant map[string]bool
+	hashes            map[[2]string]map[string]bool
+
+	// mu guards the changes collected until they are applied.
+	mu      sync.Mutex
+	changes []similarityChange
 }
 
+// DefaultMaxFanOut is the number of folders holding a hash above which its files
+// only count in the pairs of folders sharing other files.
+const DefaultMaxFanOut = 100
+
 // CalculateSimilarity computes folder similarity based on duplicate files.
-// This method should only be called once after all files are scanned.
-// Calling it multiple times will produce incorrect results.
+// Every pair of folders holding matching files counts them as duplicates, and
+// the pairs of their ancestors below their common ancestor add these counts.
+// The files of a hash held in more than MaxFanOut folders only count in their
+// own folder and in the pairs of folders sharing files of other hashes, so the
+// pairs stay bounded by the folders sharing rare files.
+// The storage changes made afterwards are applied by ApplyChanges.
 func (s *SimilarityChecker) CalculateSimilarity(storage Storage) error {
+	s.Close()
+	s.storage = storage
+	s.unsubscribe = storage.Subscribe(s.collect)
+
 	matchedFiles, err := storage.GetMatchedFiles()
 	if err != nil {
 		return err
 	}
 
-	folders := make(map[string][2]*FolderSimilarity)
-
-	// calculate file similarity
+	s.similarityFolderPairs = make(map[string][2]*FolderSimilarity)
+	s.similarityFolderMap = make(map[string][]string)
+	s.files = make(map[*File]string)
+	s.groups = make(map[string][]*File)
+	s.common = make(map[string]bool)
+	s.commonFiles = make(map[string][]*File)
+	s.shared = make(map[string]int)
+	s.resetRelations()
+
+	// calculate file similarity, folder by folder, the common hashes first
+	// so the pairs created by the other hashes count them
 	for _, matchedFile := range matchedFiles {
-		for i := 0; i < len(matchedFile.Files); i++ {
-			for j := i + 1; j < len(matchedFile.Files); j++ {
-				folder1, folder2 := getDuplicatedFolderPair(matchedFile.Files[i].Parent, matchedFile.Files[j].Parent, folders)
-
-				if _, ok := folder1.DuplicateFiles[matchedFile.Files[i].Name]; !ok {
-					folder1.DuplicateFiles[matchedFile.Files[i].Name] = matchedFile.Files[i]
-					folder1.DuplicateFileCount++
-				}
-				if _, ok := folder2.DuplicateFiles[matchedFile.Files[j].Name]; !ok {
-					folder2.DuplicateFiles[matchedFile.Files[j].Name] = matchedFile.Files[j]
-					folder2.DuplicateFileCount++
-				}
+		for _, file := range matchedFile.Files {
+			s.files[file] = file.Parent.Path
+		}
+		s.groups[matchedFile.Hash] = slices.Clone(matchedFile.Files)
+		s.setCommon(matchedFile.Hash, s.isCommon(matchedFile.Files))
+	}
+	for _, common := range []bool{true, false} {
+		for _, matchedFile := range matchedFiles {
+			if s.common[matchedFile.Hash] == common {
+				s.matchGroup(s.groups[matchedFile.Hash], 1, false)
 			}
 		}
 	}
 
-	// apply matched folder count to parent folder
-	parentFolders := maps.Clone(folders)
-	for _, matchedFolders := range folders {
-		folder1, folder2 := matchedFolders[0], matchedFolders[1]
-		calculateParentFolderSimilarity(folder1, folder2, parentFolders)
+	// apply matched folder count to parent folder, from the counts of the files only
+	folders := slices.Collect(maps.Values(s.similarityFolderPairs))
+	counts := make([][2]int, len(folders))
+	sizes := make([][2]int64, len(folders))
+	for i, pair := range folders {
+		counts[i] = [2]int{pair[0].DuplicateFileCount, pair[1].DuplicateFileCount}
+		sizes[i] = [2]int64{pair[0].DuplicateSize, pair[1].DuplicateSize}
 	}
-
-	s.similarityFolderPairs = parentFolders
-
-	s.similarityFolderMap = make(map[string][]string)
-	for key := range s.similarityFolderPairs {
-		paths := strings.Split(key, ":")
-		if len(paths) != 2 {
-			// invalid key
-			continue
-		}
-
-		if paths[0] == paths[1] {
-			// Skip same-folder duplicates as they are handled separately
-			// TODO: Implement GetSameFolderDuplicates() method
-			continue
-		} else {
-			s.similarityFolderMap[paths[0]] = append(s.similarityFolderMap[paths[0]], key)
-			s.similarityFolderMap[paths[1]] = append(s.similarityFolderMap[paths[1]], key)
-		}
+	for i, pair := range folders {
+		s.propagate(pair[0].path, pair[1].path, pair[0].Folder, pair[1].Folder, counts[i], sizes[i])
 	}
 	return nil
 }
 
+// ContainsSimilarityGroup reports whether the folder at path or one of its subfolders
+// has a pair passing the threshold.
 func (s *SimilarityChecker) ContainsSimilarityGroup(path string) bool {
-	_, ok := s.similarityFolderMap[path]
-	if ok {
+	if s.isSimilar(path) {
 		return true
 	}
 
 	// TODO: optimize this
 	for key, _ := range s.similarityFolderMap {
-		if strings.HasPrefix(key, path+string(filepath.Separator)) {
+		if strings.HasPrefix(key, path+string(filepath.Separator)) && s.isSimilar(key) {
 			return true
 		}
 	}
@@ -245,7 +361,31 @@ func (s *SimilarityChecker) ContainsSimilarityGroup(path string) bool {
 	return false
 }
 
+// isSimilar reports whether the folder at path has a pair passing the threshold.
+func (s *SimilarityChecker) isSimilar(path string) bool {
+	for _, key := range s.similarityFolderMap[path] {
+		pair, ok := s.similarityFolderPairs[key]
+		if !ok {
+			continue
+		}
+		folder := pair[0]
+		if folder.path != path {
+			folder = pair[1]
+		}
+		if s.Threshold.Accept(folder, s.Metric) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSimilarityFolderGroup returns the pairs of the folder at path passing the threshold,
+// the folder first, most similar first.
 func (s *SimilarityChecker) GetSimilarityFolderGroup(path string) [][2]*FolderSimilarity {
+	return s.getSimilarityFolderGroup(path, s.Threshold)
+}
+
+func (s *SimilarityChecker) getSimilarityFolderGroup(path string, threshold SimilarityThreshold) [][2]*FolderSimilarity {
 	output := [][2]*FolderSimilarity{}
 
 	for _, pairPath := range s.similarityFolderMap[path] {
@@ -259,19 +399,23 @@ func (s *SimilarityChecker) GetSimilarityFolderGroup(path string) [][2]*FolderSi
 		if err != nil {
 			continue
 		}
-		if f1.Folder.Path == path {
+		if f1.Folder.Path != path {
+			f1, f2 = f2, f1
+		}
+		if threshold.Accept(f1, s.Metric) {
 			output = append(output, [2]*FolderSimilarity{f1, f2})
-		} else {
-			output = append(output, [2]*FolderSimilarity{f2, f1})
 		}
 	}
 
 	// sort output by DuplicateFileCount
 	sort.Slice(output, func(i, j int) bool {
-		p1, p2 := output[i][0].DuplicatedPercentage(), output[j][0].DuplicatedPercentage()
+		p1, p2 := output[i][0].Similarity(s.Metric), output[j][0].Similarity(s.Metric)
 		if p1 == p2 {
-			p1, p2 := output[i][1].DuplicatedPercentage(), output[j][1].DuplicatedPercentage()
+			p1, p2 := output[i][1].Similarity(s.Metric), output[j][1].Similarity(s.Metric)
 			if p1 == p2 {
+				if len(output[i][0].DuplicateFiles) == len(output[j][0].DuplicateFiles) {
+					return output[i][1].Folder.Path < output[j][1].Folder.Path
+				}
 				return len(output[i][0].DuplicateFiles) > len(output[j][0].DuplicateFiles)
 			}
 			return p1 > p2
@@ -279,7 +423,108 @@ func (s *SimilarityChecker) GetSimilarityFolderGroup(path string) [][2]*FolderSi
 		return p1 > p2
 	})
 
-	// TODO: Implement filter
+	return output
+}
+
+// GetSimilarityFolderPairs returns every pair of distinct folders sharing duplicate files
+// and passing the threshold, sorted by path.
+func (s *SimilarityChecker) GetSimilarityFolderPairs() [][2]*FolderSimilarity {
+	output := [][2]*FolderSimilarity{}
+
+	for _, pair := range s.similarityFolderPairs {
+		if pair[0].Folder.Path == pair[1].Folder.Path || !s.Threshold.Accept(pair[0], s.Metric) {
+			continue
+		}
+		if pair[0].Folder.Path < pair[1].Folder.Path {
+			output = append(output, pair)
+		} else {
+			output = append(output, [2]*FolderSimilarity{pair[1], pair[0]})
+		}
+	}
+
+	sort.Slice(output, func(i, j int) bool {
+		if output[i][0].Folder.Path == output[j][0].Folder.Path {
+			return output[i][1].Folder.Path < output[j][1].Folder.Path
+		}
+		return output[i][0].Folder.Path < output[j][0].Folder.Path
+	})
+	return output
+}
+
+// PairRanking orders the folder pairs returned by GetRankedFolderPairs.
+type PairRanking int
+
+const (
+	// RankByReclaimable ranks the pairs by the bytes freed by removing the duplicates of one side.
+	RankByReclaimable PairRanking = iota
+	// RankByPercentage ranks the pairs by the similarity of their more similar side, measured by the metric.
+	RankByPercentage
+)
+
+// ReclaimableSize returns the bytes freed by removing the duplicate files of the folder
+// with the most duplicate bytes of the pair.
+func ReclaimableSize(pair [2]*FolderSimilarity) int64 {
+	return max(pair[0].DuplicateSize, pair[1].DuplicateSize)
+}
+
+// GetRankedFolderPairs returns the pairs of GetSimilarityFolderPairs ranked by ranking,
+// highest first, at most limit pairs unless limit is zero. Pairs ranked equally are sorted by path.
+func (s *SimilarityChecker) GetRankedFolderPairs(ranking PairRanking, limit int) [][2]*FolderSimilarity {
+	output := s.GetSimilarityFolderPairs()
+	similarity := func(pair [2]*FolderSimilarity) float64 {
+		return max(pair[0].Similarity(s.Metric), pair[1].Similarity(s.Metric))
+	}
+
+	sort.SliceStable(output, func(i, j int) bool {
+		if ranking == RankByPercentage {
+			p1, p2 := similarity(output[i]), similarity(output[j])
+			if p1 != p2 {
+				return p1 > p2
+			}
+		}
+		return ReclaimableSize(output[i]) > ReclaimableSize(output[j])
+	})
+	if limit > 0 && len(output) > limit {
+		output = output[:limit]
+	}
+	return output
+}
+
+// GetSameFolderDuplicates returns the groups of duplicate files inside the folder at path,
+// sorted by the name of their first file. The files of a group are ordered with the
+// suggested copy to keep first: the shortest name, then the oldest, then by name.
+func (s *SimilarityChecker) GetSameFolderDuplicates(path string) []*MatchedFileGroup {
+	pair, ok := s.similarityFolderPairs[folderPairKey(path, path)]
+	if !ok {
+		return nil
+	}
+
+	groups := map[string]*MatchedFileGroup{}
+	for file := range pair[0].matches {
+		group, ok := groups[file.Hash]
+		if !ok {
+			group = &MatchedFileGroup{Hash: file.Hash}
+			groups[file.Hash] = group
+		}
+		group.Files = append(group.Files, file)
+	}
+
+	output := slices.Collect(maps.Values(groups))
+	for _, group := range output {
+		sort.Slice(group.Files, func(i, j int) bool {
+			f1, f2 := group.Files[i], group.Files[j]
+			if len(f1.Name) != len(f2.Name) {
+				return len(f1.Name) < len(f2.Name)
+			}
+			if !f1.ModTime.Equal(f2.ModTime) {
+				return f1.ModTime.Before(f2.ModTime)
+			}
+			return f1.Name < f2.Name
+		})
+	}
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].Files[0].Name < output[j].Files[0].Name
+	})
 	return output
 }
 
@@ -302,7 +547,8 @@ func (s *SimilarityChecker) GetChildFolderSimilarityMatch(f1, f2 *FolderSimilari
 	}
 
 	for _, f := range f1.GetFolders() {
-		groups := s.GetSimilarityFolderGroup(f.Path)
+		// the subfolders are matched by all their pairs, whatever the threshold
+		groups := s.getSimilarityFolderGroup(f.Path, SimilarityThreshold{})
 
 		matched := false
 		for _, group := range groups {
@@ -340,6 +586,8 @@ func (s *SimilarityChecker) DeleteSimilarityGroup(folder1, folder2 *FolderSimila
 
 	f1DuplicateFileCount := folder1.DuplicateFileCount
 	f2DuplicateFileCount := folder2.DuplicateFileCount
+	f1DuplicateSize := folder1.DuplicateSize
+	f2DuplicateSize := folder2.DuplicateSize
 
 	deletedKeys := []string{}
 
@@ -354,6 +602,8 @@ func (s *SimilarityChecker) DeleteSimilarityGroup(folder1, folder2 *FolderSimila
 				f1, f2 := getDuplicatedFolderPair(currentFolder1, currentFolder2, s.similarityFolderPairs)
 				f1.DuplicateFileCount -= f1DuplicateFileCount
 				f2.DuplicateFileCount -= f2DuplicateFileCount
+				f1.DuplicateSize -= f1DuplicateSize
+				f2.DuplicateSize -= f2DuplicateSize
 
 				if f2.DuplicateFileCount == 0 || f1.DuplicateFileCount == 0 {
 					delete(s.similarityFolderPairs, key)
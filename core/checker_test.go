@@ -0,0 +1,258 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// BenchmarkCalculateSimilarityCommonGroup builds the similarity of 5,000 folders holding the
+// same file and a unique one, next to two copies of an album.
+func BenchmarkCalculateSimilarityCommonGroup(b *testing.B) {
+	storage := NewMemoryStorage()
+	for i := 0; i < 5000; i++ {
+		files := []*File{
+			{Name: "icon.png", Path: fmt.Sprintf("apps/app%d/icon.png", i), Size: 100, Hash: "AAAA"},
+			{Name: "app", Path: fmt.Sprintf("apps/app%d/app", i), Size: 1000, Hash: fmt.Sprintf("B%03d", i)},
+		}
+		if err := storage.AddFiles(files); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, album := range []string{"music/album", "backup/album"} {
+		for i := 0; i < 10; i++ {
+			file := &File{Name: fmt.Sprintf("track%d", i), Path: fmt.Sprintf("%s/track%d", album, i), Size: 5000, Hash: fmt.Sprintf("C%03d", i)}
+			if err := storage.AddFile(file); err != nil {
+				b.Fatal(err)
+			}
+		}
+		file := &File{Name: "icon.png", Path: album + "/icon.png", Size: 100, Hash: "AAAA"}
+		if err := storage.AddFile(file); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		checker := &SimilarityChecker{}
+		if err := checker.CalculateSimilarity(storage); err != nil {
+			b.Fatal(err)
+		}
+		checker.Close()
+	}
+}
+
+func TestMaxFanOutBelowLimit(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		storage := NewMemoryStorage()
+		for id := 0; id < 200; id++ {
+			hash := r.Intn(30)
+			file := &File{
+				Name: fmt.Sprintf("f%d", id),
+				Path: fmt.Sprintf("d%d/e%d/g%d/f%d", r.Intn(4), r.Intn(4), r.Intn(3), id),
+				Size: int64(100 * (hash + 1)),
+				Hash: fmt.Sprintf("AA%02d", hash),
+			}
+			if err := storage.AddFile(file); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		unlimited := &SimilarityChecker{MaxFanOut: -1}
+		if err := unlimited.CalculateSimilarity(storage); err != nil {
+			t.Fatal(err)
+		}
+		limited := &SimilarityChecker{}
+		if err := limited.CalculateSimilarity(storage); err != nil {
+			t.Fatal(err)
+		}
+		if len(limited.common) != 0 {
+			t.Fatalf("seed %d: %d hashes are common below the limit", seed, len(limited.common))
+		}
+		if got, want := dumpSimilarity(limited), dumpSimilarity(unlimited); got != want {
+			t.Fatalf("seed %d: default MaxFanOut differs from no limit\ngot:\n%s\nwant:\n%s", seed, got, want)
+		}
+		unlimited.Close()
+		limited.Close()
+	}
+}
+
+// referencePair is a folder pair of referenceSimilarity, its sides ordered by path.
+type referencePair struct {
+	folders    [2]*Folder
+	duplicates [2]map[*File]bool
+	counts     [2]int
+	sizes      [2]int64
+}
+
+// referenceSimilarity computes the folder pairs as CalculateSimilarity did before common files were
+// handled: every two files of a hash count in the pair of their folders, and the counts of every pair
+// are added to the pairs of the ancestors below their common ancestor. It returns them as dumpSimilarity.
+func referenceSimilarity(t *testing.T, storage Storage) string {
+	t.Helper()
+	pairs := map[string]*referencePair{}
+	pair := func(folder1 *Folder, folder2 *Folder) (*referencePair, int, int) {
+		side1, side2 := 0, 1
+		if folder1.Path > folder2.Path {
+			side1, side2 = 1, 0
+		}
+		key := folderPairKey(folder1.Path, folder2.Path)
+		if _, ok := pairs[key]; !ok {
+			p := &referencePair{duplicates: [2]map[*File]bool{{}, {}}}
+			p.folders[side1], p.folders[side2] = folder1, folder2
+			pairs[key] = p
+		}
+		return pairs[key], side1, side2
+	}
+
+	groups, err := storage.GetMatchedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, group := range groups {
+		for i, file1 := range group.Files {
+			for _, file2 := range group.Files[i+1:] {
+				p, side1, side2 := pair(file1.Parent, file2.Parent)
+				if file1.Parent == file2.Parent {
+					side1, side2 = 0, 1
+					p.duplicates[side2][file1] = true
+					p.duplicates[side1][file2] = true
+				}
+				p.duplicates[side1][file1] = true
+				p.duplicates[side2][file2] = true
+			}
+		}
+	}
+
+	// the counts of the files only, before any is added to the ancestors
+	leaves := []referencePair{}
+	for _, p := range pairs {
+		for side := range p.duplicates {
+			for file := range p.duplicates[side] {
+				p.counts[side]++
+				p.sizes[side] += file.Size
+			}
+		}
+		leaves = append(leaves, *p)
+	}
+	chain := func(folder *Folder, other string) []*Folder {
+		folders := []*Folder{}
+		for ; folder != nil && !isSubPath(other, folder.Path); folder = folder.Parent {
+			folders = append(folders, folder)
+		}
+		return folders
+	}
+	for _, leaf := range leaves {
+		if leaf.folders[0] == leaf.folders[1] {
+			continue
+		}
+		counts, sizes := leaf.counts, leaf.sizes
+		for i, folder1 := range chain(leaf.folders[0], leaf.folders[1].Path) {
+			for j, folder2 := range chain(leaf.folders[1], leaf.folders[0].Path) {
+				if i == 0 && j == 0 {
+					continue
+				}
+				p, side1, side2 := pair(folder1, folder2)
+				p.counts[side1] += counts[0]
+				p.sizes[side1] += sizes[0]
+				p.counts[side2] += counts[1]
+				p.sizes[side2] += sizes[1]
+			}
+		}
+	}
+
+	lines := []string{}
+	folderKeys := map[string][]string{}
+	for key, p := range pairs {
+		for side, folder := range p.folders {
+			names := []string{}
+			for file := range p.duplicates[side] {
+				names = append(names, file.Name)
+			}
+			sort.Strings(names)
+			lines = append(lines, fmt.Sprintf("%s: %s files=%d size=%d duplicates=%d/%d %v",
+				key, folder.Path, folder.GetFileCount(), folder.GetFileSize(), p.counts[side], p.sizes[side], names))
+		}
+		if p.folders[0] != p.folders[1] {
+			for _, folder := range p.folders {
+				folderKeys[folder.Path] = append(folderKeys[folder.Path], key)
+			}
+		}
+	}
+	for path, keys := range folderKeys {
+		sort.Strings(keys)
+		lines = append(lines, fmt.Sprintf("%s: %v", path, keys))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func TestCalculateSimilarityMatchesPairwise(t *testing.T) {
+	// an album with its copy, a partial copy, a nested copy, duplicates inside a folder and empty files
+	fixture := []struct {
+		path string
+		hash string
+		size int64
+	}{
+		{"music/album/01.flac", "AA01", 3000},
+		{"music/album/02.flac", "AA02", 3100},
+		{"music/album/cd2/03.flac", "AA03", 3200},
+		{"music/album/cover.jpg", "AA04", 100},
+		{"backup/music/album/01.flac", "AA01", 3000},
+		{"backup/music/album/02.flac", "AA02", 3100},
+		{"backup/music/album/cd2/03.flac", "AA03", 3200},
+		{"backup/music/album/cover.jpg", "AA04", 100},
+		{"backup/partial/01.flac", "AA01", 3000},
+		{"backup/partial/notes.txt", "AA05", 10},
+		{"music/album/old/02 copy.flac", "AA02", 3100},
+		{"docs/a.txt", "AA06", 50},
+		{"docs/a copy.txt", "AA06", 50},
+		{"docs/b.txt", "AA07", 60},
+		{"docs/empty1", "AA08", 0},
+		{"music/empty2", "AA08", 0},
+	}
+	fixtureStorage := NewMemoryStorage()
+	for _, f := range fixture {
+		file := &File{Name: filepath.Base(f.path), Path: f.path, Size: f.size, Hash: f.hash}
+		if err := fixtureStorage.AddFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storages := []*MemoryStorage{fixtureStorage}
+
+	for seed := int64(0); seed < 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		storage := NewMemoryStorage()
+		for id := 0; id < 150; id++ {
+			hash := r.Intn(40)
+			file := &File{
+				Name: fmt.Sprintf("f%d", id),
+				Path: fmt.Sprintf("d%d/e%d/g%d/f%d", r.Intn(3), r.Intn(3), r.Intn(3), id),
+				Size: int64(100 * hash),
+				Hash: fmt.Sprintf("AA%02d", hash),
+			}
+			if err := storage.AddFile(file); err != nil {
+				t.Fatal(err)
+			}
+		}
+		storages = append(storages, storage)
+	}
+
+	for i, storage := range storages {
+		want := referenceSimilarity(t, storage)
+		for _, maxFanOut := range []int{0, -1} {
+			checker := &SimilarityChecker{MaxFanOut: maxFanOut}
+			if err := checker.CalculateSimilarity(storage); err != nil {
+				t.Fatal(err)
+			}
+			if got := dumpSimilarity(checker); got != want {
+				t.Fatalf("storage %d, MaxFanOut %d: pairs differ from the pairwise computation\ngot:\n%s\nwant:\n%s", i, maxFanOut, got, want)
+			}
+			checker.Close()
+		}
+	}
+}
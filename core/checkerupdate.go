@@ -0,0 +1,628 @@
+package core
+
+import (
+	"path/filepath"
+	"slices"
+)
+
+// similarityChange is a storage change collected by the similarity checker.
+type similarityChange struct {
+	ChangeEvent
+	// folderPath is the path of the event folder when the change was published.
+	folderPath string
+}
+
+// folderLink is a folder on the path from a folder to the root.
+type folderLink struct {
+	path string
+	// folder is nil when the pairs are only looked up.
+	folder *Folder
+}
+
+// collect records a storage change to be applied by ApplyChanges.
+func (s *SimilarityChecker) collect(event ChangeEvent) {
+	change := similarityChange{ChangeEvent: event}
+	if event.Folder != nil {
+		change.folderPath = event.Folder.Path
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changes = append(s.changes, change)
+}
+
+// Close stops collecting the changes of the storage.
+func (s *SimilarityChecker) Close() {
+	if s.unsubscribe != nil {
+		s.unsubscribe()
+		s.unsubscribe = nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changes = nil
+}
+
+// ApplyChanges updates the similarity with the storage changes made since the
+// last call, giving the same result as calling CalculateSimilarity again.
+// Only the files changed and the pairs of their folders are visited.
+func (s *SimilarityChecker) ApplyChanges() error {
+	s.mu.Lock()
+	changes := s.changes
+	s.changes = nil
+	s.mu.Unlock()
+	if len(changes) == 0 || s.storage == nil {
+		return nil
+	}
+	s.resetRelations()
+
+	changed := map[*File]bool{}
+	changedPaths := map[string]bool{}
+	removedPaths := []string{}
+	for _, change := range changes {
+		switch change.Type {
+		case FileAdded, FileRemoved:
+			changed[change.File] = true
+			changedPaths[change.folderPath] = true
+		case FolderRemoved:
+			removedPaths = append(removedPaths, change.Path)
+			changedPaths[change.Path] = true
+		case FolderMoved:
+			// the files are indexed again at their new path
+			removedPaths = append(removedPaths, change.Path)
+			changedPaths[change.Path] = true
+			changedPaths[change.folderPath] = true
+		}
+	}
+	for file, path := range s.files {
+		for _, removedPath := range removedPaths {
+			if isSubPath(path, removedPath) {
+				changed[file] = true
+				break
+			}
+		}
+	}
+
+	// remove the files as indexed, then add the files still in storage as they are now
+	hashes := map[string]bool{}
+	for file := range changed {
+		if _, ok := s.files[file]; ok {
+			s.removeFile(file)
+			hashes[file.Hash] = true
+		}
+	}
+	// only the files left are counted again when their hash is no longer common or rare
+	for hash := range hashes {
+		s.rematch(hash)
+	}
+	root, err := s.storage.GetFolder(".")
+	if err != nil {
+		return err
+	}
+	for file := range changed {
+		if _, ok := s.files[file]; ok || file.Size == 0 || !isInFolder(file, root) {
+			continue
+		}
+		if err := s.addFile(file); err != nil {
+			return err
+		}
+	}
+
+	// the file counts of the folders and their ancestors changed
+	for path := range changedPaths {
+		for _, link := range folderChain(path, "", nil) {
+			s.updateFileCount(link.path)
+		}
+	}
+	return nil
+}
+
+// addFile indexes the file and counts it with the indexed files of the same hash.
+// The other files of the hash which were not duplicated before are looked up in
+// the storage and indexed first, an indexed hash already holds all of them.
+func (s *SimilarityChecker) addFile(file *File) error {
+	files, ok := s.groups[file.Hash]
+	if !ok {
+		var err error
+		files, err = s.storage.FindByHash(file.Hash)
+		if err != nil {
+			return err
+		}
+	}
+	if !slices.Contains(files, file) {
+		files = append(slices.Clip(files), file)
+	}
+	if len(files) < 2 {
+		return nil
+	}
+
+	for _, f := range files {
+		if _, ok := s.files[f]; ok || f.Parent == nil {
+			continue
+		}
+		s.files[f] = f.Parent.Path
+		s.linkFile(f)
+		s.groups[f.Hash] = append(s.groups[f.Hash], f)
+	}
+	s.rematch(file.Hash)
+	return nil
+}
+
+// removeFile uncounts the file with the indexed files of the same hash and removes it from the index.
+// The hash stays common or rare until rematch is called.
+func (s *SimilarityChecker) removeFile(file *File) {
+	files := slices.DeleteFunc(s.groups[file.Hash], func(f *File) bool {
+		return f == file
+	})
+	s.unlinkFile(file, files)
+	delete(s.files, file)
+
+	if len(files) < 2 {
+		// a single file has no duplicate left
+		if s.common[file.Hash] {
+			s.matchGroup(files, -1, true)
+		}
+		for _, other := range files {
+			delete(s.files, other)
+		}
+		delete(s.groups, file.Hash)
+		delete(s.common, file.Hash)
+	} else {
+		s.groups[file.Hash] = files
+	}
+}
+
+// rematch counts the indexed files of the hash again when it became common or rare.
+func (s *SimilarityChecker) rematch(hash string) {
+	files := s.groups[hash]
+	if common := s.isCommon(files); common != s.common[hash] {
+		s.matchGroup(files, -1, true)
+		s.setCommon(hash, common)
+		s.matchGroup(files, 1, true)
+	}
+}
+
+// linkFile counts the indexed file with the files of its hash, as matchGroup does for a whole group.
+func (s *SimilarityChecker) linkFile(file *File) {
+	for _, other := range s.matchingFiles(file, s.groups[file.Hash]) {
+		s.addMatch(file, other)
+	}
+	if s.common[file.Hash] {
+		path := s.files[file]
+		s.commonFiles[path] = append(s.commonFiles[path], file)
+	}
+}
+
+// unlinkFile uncounts the file counted by linkFile with the other files of its hash.
+func (s *SimilarityChecker) unlinkFile(file *File, others []*File) {
+	for _, other := range s.matchingFiles(file, others) {
+		s.removeMatch(file, other)
+	}
+	if s.common[file.Hash] {
+		path := s.files[file]
+		s.commonFiles[path] = slices.DeleteFunc(s.commonFiles[path], func(f *File) bool {
+			return f == file
+		})
+		if len(s.commonFiles[path]) == 0 {
+			delete(s.commonFiles, path)
+		}
+	}
+}
+
+// matchingFiles returns the files of others counted with the file: all of them for a rare hash,
+// and for a common hash the files in the same folder or in a folder sharing rare files.
+func (s *SimilarityChecker) matchingFiles(file *File, others []*File) []*File {
+	if !s.common[file.Hash] {
+		return others
+	}
+	path := s.files[file]
+	folders := map[string]bool{path: true}
+	for _, other := range s.sharedFolders(path) {
+		folders[other] = true
+	}
+	matching := []*File{}
+	for _, other := range others {
+		if folders[s.files[other]] {
+			matching = append(matching, other)
+		}
+	}
+	return matching
+}
+
+// addMatch counts two files with the same hash as duplicates in the pair of
+// their folders and in the pairs of their ancestors.
+// Files in the same folder are counted on both sides of its pair with itself.
+func (s *SimilarityChecker) addMatch(file1 *File, file2 *File) {
+	path1, path2 := s.files[file1], s.files[file2]
+	folder1, folder2, _ := s.folderPair(file1, file2, true)
+
+	if path1 == path2 {
+		for _, folder := range []*FolderSimilarity{folder1, folder2} {
+			folder.match(file1, 1)
+			folder.match(file2, 1)
+		}
+		return
+	}
+
+	count1, size1 := folder1.match(file1, 1)
+	count2, size2 := folder2.match(file2, 1)
+	s.propagate(path1, path2, file1.Parent, file2.Parent, [2]int{count1, count2}, [2]int64{size1, size2})
+	if !s.common[file1.Hash] {
+		s.share(path1, path2, 1, true)
+	}
+}
+
+// removeMatch uncounts two files counted by addMatch.
+func (s *SimilarityChecker) removeMatch(file1 *File, file2 *File) {
+	path1, path2 := s.files[file1], s.files[file2]
+	if path1 != path2 && !s.common[file1.Hash] {
+		// the files of common hashes are uncounted while the pair still holds these files
+		s.share(path1, path2, -1, true)
+	}
+	folder1, folder2, ok := s.folderPair(file1, file2, false)
+	if !ok {
+		return
+	}
+
+	if path1 == path2 {
+		for _, folder := range []*FolderSimilarity{folder1, folder2} {
+			folder.match(file1, -1)
+			folder.match(file2, -1)
+		}
+		s.prune(folder1)
+		return
+	}
+
+	count1, size1 := folder1.match(file1, -1)
+	count2, size2 := folder2.match(file2, -1)
+	s.prune(folder1)
+	s.propagate(path1, path2, nil, nil, [2]int{count1, count2}, [2]int64{size1, size2})
+}
+
+// matchGroup counts the indexed files of a hash as duplicates by delta, one pair of folders
+// at a time, and propagates the counts to the ancestors when propagate is set. The files of
+// a common hash only match in their own folder and in the folders sharing rare files.
+func (s *SimilarityChecker) matchGroup(files []*File, delta int, propagate bool) {
+	if len(files) == 0 {
+		return
+	}
+	hash := files[0].Hash
+	paths, folders := s.filesByFolder(files)
+	for i, path := range paths {
+		s.matchFolders(folders[path], folders[path], delta, propagate)
+		if !s.common[hash] {
+			for _, other := range paths[i+1:] {
+				s.matchFolders(folders[path], folders[other], delta, propagate)
+			}
+			continue
+		}
+		for _, other := range s.sharedFolders(path) {
+			if other > path && len(folders[other]) > 0 {
+				s.matchFolders(folders[path], folders[other], delta, propagate)
+			}
+		}
+	}
+
+	if !s.common[hash] {
+		return
+	}
+	for _, path := range paths {
+		if delta > 0 {
+			s.commonFiles[path] = append(s.commonFiles[path], folders[path]...)
+			continue
+		}
+		s.commonFiles[path] = slices.DeleteFunc(s.commonFiles[path], func(f *File) bool {
+			return f.Hash == hash && slices.Contains(folders[path], f)
+		})
+		if len(s.commonFiles[path]) == 0 {
+			delete(s.commonFiles, path)
+		}
+	}
+}
+
+// matchFolders counts by delta the files of a hash in one folder as matching every file of
+// the hash in another folder, or every other file when both are in the same folder,
+// like addMatch for each pair of files.
+func (s *SimilarityChecker) matchFolders(files1 []*File, files2 []*File, delta int, propagate bool) {
+	path1, path2 := s.files[files1[0]], s.files[files2[0]]
+	if path1 == path2 {
+		if len(files1) < 2 {
+			return
+		}
+		folder1, folder2, ok := s.folderPair(files1[0], files2[0], delta > 0)
+		if !ok {
+			return
+		}
+		for _, folder := range []*FolderSimilarity{folder1, folder2} {
+			for _, file := range files1 {
+				folder.match(file, delta*(len(files1)-1))
+			}
+		}
+		if delta < 0 {
+			s.prune(folder1)
+		}
+		return
+	}
+
+	rare := !s.common[files1[0].Hash]
+	if rare && delta < 0 {
+		s.share(path1, path2, delta*len(files1)*len(files2), propagate)
+	}
+	folder1, folder2, ok := s.folderPair(files1[0], files2[0], delta > 0)
+	if !ok {
+		return
+	}
+	counts, sizes := [2]int{}, [2]int64{}
+	for _, file := range files1 {
+		count, size := folder1.match(file, delta*len(files2))
+		counts[0] += count
+		sizes[0] += size
+	}
+	for _, file := range files2 {
+		count, size := folder2.match(file, delta*len(files1))
+		counts[1] += count
+		sizes[1] += size
+	}
+
+	if delta < 0 {
+		s.prune(folder1)
+		if propagate {
+			s.propagate(path1, path2, nil, nil, counts, sizes)
+		}
+		return
+	}
+	if propagate {
+		s.propagate(path1, path2, files1[0].Parent, files2[0].Parent, counts, sizes)
+	}
+	if rare {
+		s.share(path1, path2, delta*len(files1)*len(files2), propagate)
+	}
+}
+
+// share changes by delta the matching files of rare hashes in the pair of the distinct folders
+// at path1 and path2. The files of common hashes in both folders are counted while the
+// pair shares rare files.
+func (s *SimilarityChecker) share(path1 string, path2 string, delta int, propagate bool) {
+	key := folderPairKey(path1, path2)
+	before := s.shared[key]
+	after := before + delta
+	if after > 0 {
+		s.shared[key] = after
+	} else {
+		delete(s.shared, key)
+	}
+
+	if before == 0 && after > 0 {
+		s.matchCommon(path1, path2, 1, propagate)
+	} else if before > 0 && after <= 0 {
+		s.matchCommon(path1, path2, -1, propagate)
+	}
+}
+
+// matchCommon counts by delta the files of the common hashes held in both folders at path1 and path2.
+func (s *SimilarityChecker) matchCommon(path1 string, path2 string, delta int, propagate bool) {
+	if len(s.commonFiles[path1]) == 0 || len(s.commonFiles[path2]) == 0 {
+		return
+	}
+	files1, files2 := filesByHash(s.commonFiles[path1]), filesByHash(s.commonFiles[path2])
+	for hash, files := range files1 {
+		if len(files2[hash]) > 0 {
+			s.matchFolders(files, files2[hash], delta, propagate)
+		}
+	}
+}
+
+// sharedFolders returns the paths of the folders sharing rare files with the folder at path.
+func (s *SimilarityChecker) sharedFolders(path string) []string {
+	paths := []string{}
+	for _, key := range s.similarityFolderMap[path] {
+		pair, ok := s.similarityFolderPairs[key]
+		if !ok || s.shared[key] == 0 {
+			continue
+		}
+		if pair[0].path == path {
+			paths = append(paths, pair[1].path)
+		} else {
+			paths = append(paths, pair[0].path)
+		}
+	}
+	return paths
+}
+
+// folderPair returns the pair of the folders holding the indexed files, the folder of file1 first.
+// A missing pair is created when create is set.
+func (s *SimilarityChecker) folderPair(file1 *File, file2 *File, create bool) (*FolderSimilarity, *FolderSimilarity, bool) {
+	path1, path2 := s.files[file1], s.files[file2]
+	if !create {
+		folder1, folder2, err := getFolderSimilarity(path1, path2, s.similarityFolderPairs)
+		return folder1, folder2, err == nil
+	}
+
+	if path1 != path2 {
+		key := folderPairKey(path1, path2)
+		if _, ok := s.similarityFolderPairs[key]; !ok {
+			s.similarityFolderMap[path1] = append(s.similarityFolderMap[path1], key)
+			s.similarityFolderMap[path2] = append(s.similarityFolderMap[path2], key)
+		}
+	}
+	folder1, folder2 := getDuplicatedFolderPair(file1.Parent, file2.Parent, s.similarityFolderPairs)
+	return folder1, folder2, true
+}
+
+// filesByFolder groups the indexed files by the path of their folder, in the order of the first file of each folder.
+func (s *SimilarityChecker) filesByFolder(files []*File) ([]string, map[string][]*File) {
+	paths := []string{}
+	folders := map[string][]*File{}
+	for _, file := range files {
+		path := s.files[file]
+		if _, ok := folders[path]; !ok {
+			paths = append(paths, path)
+		}
+		folders[path] = append(folders[path], file)
+	}
+	return paths, folders
+}
+
+// filesByHash groups the files by hash.
+func filesByHash(files []*File) map[string][]*File {
+	hashes := map[string][]*File{}
+	for _, file := range files {
+		hashes[file.Hash] = append(hashes[file.Hash], file)
+	}
+	return hashes
+}
+
+// isCommon reports whether the indexed files of a hash are held in more than MaxFanOut folders.
+func (s *SimilarityChecker) isCommon(files []*File) bool {
+	limit := s.MaxFanOut
+	if limit == 0 {
+		limit = DefaultMaxFanOut
+	}
+	if limit < 0 || len(files) <= limit {
+		return false
+	}
+	paths := map[string]bool{}
+	for _, file := range files {
+		paths[s.files[file]] = true
+		if len(paths) > limit {
+			return true
+		}
+	}
+	return false
+}
+
+// setCommon records whether the hash is common.
+func (s *SimilarityChecker) setCommon(hash string, common bool) {
+	if common {
+		s.common[hash] = true
+	} else {
+		delete(s.common, hash)
+	}
+}
+
+// propagate adds the change of the duplicates counted in the pair of the folders
+// at path1 and path2 to the pairs of their ancestors below their common ancestor.
+// Missing pairs are created from folder1 and folder2 and their parents, if given.
+func (s *SimilarityChecker) propagate(path1 string, path2 string, folder1 *Folder, folder2 *Folder, counts [2]int, sizes [2]int64) {
+	if counts == [2]int{} && sizes == [2]int64{} {
+		return
+	}
+
+	chain1 := folderChain(path1, path2, folder1)
+	chain2 := folderChain(path2, path1, folder2)
+	for i, link1 := range chain1 {
+		for j, link2 := range chain2 {
+			if i == 0 && j == 0 {
+				continue
+			}
+
+			f1, f2, err := getFolderSimilarity(link1.path, link2.path, s.similarityFolderPairs)
+			if err != nil {
+				if link1.folder == nil || link2.folder == nil {
+					continue
+				}
+				key := folderPairKey(link1.path, link2.path)
+				s.similarityFolderMap[link1.path] = append(s.similarityFolderMap[link1.path], key)
+				s.similarityFolderMap[link2.path] = append(s.similarityFolderMap[link2.path], key)
+				f1, f2 = getDuplicatedFolderPair(link1.folder, link2.folder, s.similarityFolderPairs)
+			}
+
+			f1.DuplicateFileCount += counts[0]
+			f1.DuplicateSize += sizes[0]
+			f2.DuplicateFileCount += counts[1]
+			f2.DuplicateSize += sizes[1]
+			s.prune(f1)
+		}
+	}
+}
+
+// prune removes the pair of the folder once no duplicate is counted on either side.
+func (s *SimilarityChecker) prune(folder *FolderSimilarity) {
+	target := folder.TargetFolder
+	if folder.DuplicateFileCount != 0 || target.DuplicateFileCount != 0 {
+		return
+	}
+
+	key := folderPairKey(folder.path, target.path)
+	delete(s.similarityFolderPairs, key)
+	if folder.path == target.path {
+		return
+	}
+	for _, path := range []string{folder.path, target.path} {
+		s.similarityFolderMap[path] = slices.DeleteFunc(s.similarityFolderMap[path], func(k string) bool {
+			return k == key
+		})
+		if len(s.similarityFolderMap[path]) == 0 {
+			delete(s.similarityFolderMap, path)
+		}
+	}
+}
+
+// updateFileCount updates the file count and size of the folder at path in all its pairs.
+func (s *SimilarityChecker) updateFileCount(path string) {
+	keys := append([]string{folderPairKey(path, path)}, s.similarityFolderMap[path]...)
+	for _, key := range keys {
+		for _, folder := range s.similarityFolderPairs[key] {
+			if folder != nil && folder.path == path {
+				folder.FileCount = folder.Folder.GetFileCount()
+				folder.TotalSize = folder.Folder.GetFileSize()
+			}
+		}
+	}
+}
+
+// match changes the number of matching files in the target folder for the file
+// by delta and returns the change of the duplicate files and bytes counted.
+func (f *FolderSimilarity) match(file *File, delta int) (int, int64) {
+	if f.matches == nil {
+		f.matches = make(map[*File]int)
+	}
+	before := f.matches[file]
+	after := before + delta
+	if after > 0 {
+		f.matches[file] = after
+	} else {
+		delete(f.matches, file)
+	}
+
+	if before == 0 && after > 0 {
+		f.DuplicateFiles[file.Name] = file
+		f.DuplicateFileCount++
+		f.DuplicateSize += file.Size
+		return 1, file.Size
+	} else if before > 0 && after <= 0 {
+		if f.DuplicateFiles[file.Name] == file {
+			delete(f.DuplicateFiles, file.Name)
+		}
+		f.DuplicateFileCount--
+		f.DuplicateSize -= file.Size
+		return -1, -file.Size
+	}
+	return 0, 0
+}
+
+// folderChain returns the folder at path and its ancestors which do not contain
+// the path other, nearest first. The folders are set from folder and its parents.
+func folderChain(path string, other string, folder *Folder) []folderLink {
+	chain := []folderLink{}
+	for other == "" || !isSubPath(other, path) {
+		chain = append(chain, folderLink{path: path, folder: folder})
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+		if folder != nil {
+			folder = folder.Parent
+		}
+	}
+	return chain
+}
+
+// isInFolder reports whether the file is still stored below the folder.
+func isInFolder(file *File, folder *Folder) bool {
+	parent := file.Parent
+	for parent != nil && parent != folder {
+		parent = parent.Parent
+	}
+	return parent == folder
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// dumpSimilarity returns the pairs of the checker with their counts and duplicate files, and the pairs of every folder.
+func dumpSimilarity(s *SimilarityChecker) string {
+	lines := []string{}
+	for key, pair := range s.similarityFolderPairs {
+		for _, folder := range pair {
+			names := []string{}
+			for name := range folder.DuplicateFiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			lines = append(lines, fmt.Sprintf("%s: %s files=%d size=%d duplicates=%d/%d %v",
+				key, folder.path, folder.FileCount, folder.TotalSize,
+				folder.DuplicateFileCount, folder.DuplicateSize, names))
+		}
+	}
+	for path, keys := range s.similarityFolderMap {
+		keys = slices.Clone(keys)
+		sort.Strings(keys)
+		lines = append(lines, fmt.Sprintf("%s: %v", path, keys))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// randomChange makes a random change to the storage: adding or removing a file, removing or moving a folder.
+func randomChange(r *rand.Rand, storage *MemoryStorage, id int) error {
+	folder := fmt.Sprintf("d%d/e%d/g%d", r.Intn(3), r.Intn(3), r.Intn(2))
+	switch n := r.Intn(10); {
+	case n < 5:
+		hash := r.Intn(6)
+		return storage.AddFile(&File{
+			Name: fmt.Sprintf("f%d", id),
+			Path: fmt.Sprintf("%s/f%d", folder, id),
+			Size: int64(100 * (hash + 1)),
+			Hash: fmt.Sprintf("AAA%c", 'A'+hash),
+		})
+	case n < 8:
+		files := []*File{}
+		for file := range storage.Walk(".") {
+			files = append(files, file)
+		}
+		if len(files) == 0 {
+			return nil
+		}
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Path < files[j].Path
+		})
+		return storage.RemoveFile(files[r.Intn(len(files))])
+	case n < 9:
+		if _, ok := storage.folders.Load(folder); !ok {
+			return nil
+		}
+		return storage.RemoveFolder(folder)
+	default:
+		src := fmt.Sprintf("d%d/e%d", r.Intn(3), r.Intn(3))
+		dst := fmt.Sprintf("d%d", r.Intn(3))
+		if _, ok := storage.folders.Load(src); !ok || strings.HasPrefix(src, dst+"/") {
+			return nil
+		}
+		if _, ok := storage.folders.Load(dst + "/" + src[3:]); ok {
+			return nil
+		}
+		return storage.MoveFolder(src, dst)
+	}
+}
+
+func TestApplyChangesMatchesCalculateSimilarity(t *testing.T) {
+	for _, maxFanOut := range []int{-1, 0, 3, 2} {
+		t.Run(fmt.Sprintf("MaxFanOut=%d", maxFanOut), func(t *testing.T) {
+			for seed := int64(0); seed < 40; seed++ {
+				r := rand.New(rand.NewSource(seed))
+				storage := NewMemoryStorage()
+				id := 0
+				for ; id < 30; id++ {
+					if err := randomChange(r, storage, id); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				checker := &SimilarityChecker{MaxFanOut: maxFanOut}
+				if err := checker.CalculateSimilarity(storage); err != nil {
+					t.Fatal(err)
+				}
+				for step := 0; step < 30; step++ {
+					for n := r.Intn(3) + 1; n > 0; n-- {
+						id++
+						if err := randomChange(r, storage, id); err != nil {
+							t.Fatal(err)
+						}
+					}
+					if err := checker.ApplyChanges(); err != nil {
+						t.Fatal(err)
+					}
+
+					fresh := &SimilarityChecker{MaxFanOut: maxFanOut}
+					if err := fresh.CalculateSimilarity(storage); err != nil {
+						t.Fatal(err)
+					}
+					got, want := dumpSimilarity(checker), dumpSimilarity(fresh)
+					fresh.Close()
+					if got != want {
+						t.Fatalf("seed %d step %d: applied changes differ from a new calculation\ngot:\n%s\nwant:\n%s", seed, step, got, want)
+					}
+				}
+				checker.Close()
+			}
+		})
+	}
+}
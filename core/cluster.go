@@ -0,0 +1,226 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// FolderCluster is a group of folders similar to each other, like copies of the same album.
+type FolderCluster struct {
+	// Members are sorted by path. Their duplicates count the files with a copy in another member,
+	// their target folder is nil.
+	Members []*FolderSimilarity
+}
+
+// DuplicateSize returns the duplicate bytes of all members.
+func (c *FolderCluster) DuplicateSize() int64 {
+	size := int64(0)
+	for _, member := range c.Members {
+		size += member.DuplicateSize
+	}
+	return size
+}
+
+// GetFolderClusters groups the folders of the pairs passing the threshold and similar by at least
+// minPercentage on both sides, as measured by the metric. Every member of a cluster is similar to
+// every other member, so a chain of folders each similar to the next is not one cluster. The most
+// similar pairs are joined first, and a folder is never joined with a folder inside it.
+// Clusters are sorted by size, then by duplicate bytes.
+func (s *SimilarityChecker) GetFolderClusters(minPercentage float64) []*FolderCluster {
+	edges := [][2]*FolderSimilarity{}
+	similar := map[string]bool{}
+	for _, pair := range s.GetSimilarityFolderPairs() {
+		if isSubPath(pair[0].path, pair[1].path) || isSubPath(pair[1].path, pair[0].path) {
+			continue
+		}
+		if min(pair[0].Similarity(s.Metric), pair[1].Similarity(s.Metric)) >= minPercentage {
+			edges = append(edges, pair)
+			similar[folderPairKey(pair[0].path, pair[1].path)] = true
+		}
+	}
+	sort.SliceStable(edges, func(i, j int) bool {
+		return min(edges[i][0].Similarity(s.Metric), edges[i][1].Similarity(s.Metric)) >
+			min(edges[j][0].Similarity(s.Metric), edges[j][1].Similarity(s.Metric))
+	})
+
+	// join the clusters of the folders of every edge when all their members are similar to each other
+	clusters := map[string]*[]*Folder{}
+	clusterOf := func(folder *Folder) *[]*Folder {
+		if cluster, ok := clusters[folder.Path]; ok {
+			return cluster
+		}
+		return &[]*Folder{folder}
+	}
+	for _, edge := range edges {
+		cluster1, cluster2 := clusterOf(edge[0].Folder), clusterOf(edge[1].Folder)
+		if cluster1 == cluster2 || !isLinkedCluster(*cluster1, *cluster2, similar) {
+			continue
+		}
+		*cluster1 = append(*cluster1, *cluster2...)
+		for _, folder := range *cluster1 {
+			clusters[folder.Path] = cluster1
+		}
+	}
+
+	output := []*FolderCluster{}
+	seen := map[*[]*Folder]bool{}
+	for _, folders := range clusters {
+		if seen[folders] {
+			continue
+		}
+		seen[folders] = true
+		output = append(output, s.newFolderCluster(*folders))
+	}
+	sort.Slice(output, func(i, j int) bool {
+		if len(output[i].Members) != len(output[j].Members) {
+			return len(output[i].Members) > len(output[j].Members)
+		}
+		if output[i].DuplicateSize() != output[j].DuplicateSize() {
+			return output[i].DuplicateSize() > output[j].DuplicateSize()
+		}
+		return output[i].Members[0].Path < output[j].Members[0].Path
+	})
+	return output
+}
+
+// GenerateClusterPlan returns the tasks keeping the member at index keeper of the cluster.
+// The files of the other members with a copy in the keeper are deleted; the others are moved
+// into the keeper when merge is set, into the same subfolder if it exists, and deleted otherwise.
+// The emptied members are deleted last.
+func (s *SimilarityChecker) GenerateClusterPlan(cluster *FolderCluster, keeper int, merge bool) []FileActionTask {
+	target := cluster.Members[keeper].Folder
+	hashes := map[string]bool{}
+	names := map[string]bool{}
+	walkFolders(target, func(folder *Folder) {
+		for _, file := range folder.GetFiles() {
+			hashes[file.Hash] = true
+			names[file.Path] = true
+		}
+	})
+
+	actions := []FileActionTask{}
+	for i, member := range cluster.Members {
+		if i == keeper {
+			continue
+		}
+
+		folders := []*Folder{}
+		walkFolders(member.Folder, func(folder *Folder) {
+			folders = append(folders, folder)
+		})
+		sort.Slice(folders, func(i, j int) bool {
+			return folders[i].Path < folders[j].Path
+		})
+
+		for _, folder := range folders {
+			files := folder.GetFiles()
+			sortFilesByPath(files)
+			for _, file := range files {
+				if hashes[file.Hash] || !merge {
+					actions = append(actions, FileActionTask{
+						Action:       Delete,
+						File:         file,
+						NotDuplicate: !hashes[file.Hash],
+					})
+					continue
+				}
+
+				targetFolder := clusterTargetFolder(target, member.Path, folder.Path)
+				targetName := uniqueFileName(targetFolder.Path, file.Name, names)
+				names[filepath.Join(targetFolder.Path, targetName)] = true
+				hashes[file.Hash] = true
+				action := FileActionTask{Action: Move, File: file, TargetFolder: targetFolder}
+				if targetName != file.Name {
+					action.TargetName = targetName
+				}
+				actions = append(actions, action)
+			}
+		}
+
+		// subfolders before their parents
+		slices.Reverse(folders)
+		for _, folder := range folders {
+			actions = append(actions, FileActionTask{Action: DeleteEmptyFolder, Folder: folder})
+		}
+	}
+	return actions
+}
+
+// newFolderCluster counts the files of every folder with a copy in another folder of the cluster.
+func (s *SimilarityChecker) newFolderCluster(folders []*Folder) *FolderCluster {
+	hashes := make([]map[string]bool, len(folders))
+	for i, folder := range folders {
+		hashes[i] = s.folderHashes(folder.Path, folder.Path)
+	}
+
+	cluster := &FolderCluster{}
+	for i, folder := range folders {
+		member := &FolderSimilarity{
+			Folder:         folder,
+			FileCount:      folder.GetFileCount(),
+			TotalSize:      folder.GetFileSize(),
+			DuplicateFiles: make(map[string]*File),
+			path:           folder.Path,
+		}
+		for file := range s.storage.Walk(folder.Path) {
+			for j := range folders {
+				if j != i && file.Size > 0 && hashes[j][file.Hash] {
+					member.DuplicateFileCount++
+					member.DuplicateSize += file.Size
+					member.DuplicateFiles[file.Path] = file
+					break
+				}
+			}
+		}
+		cluster.Members = append(cluster.Members, member)
+	}
+	sort.Slice(cluster.Members, func(i, j int) bool {
+		return cluster.Members[i].Path < cluster.Members[j].Path
+	})
+	return cluster
+}
+
+// isLinkedCluster reports whether every folder of folders1 is similar to every folder of folders2.
+func isLinkedCluster(folders1 []*Folder, folders2 []*Folder, similar map[string]bool) bool {
+	for _, folder1 := range folders1 {
+		for _, folder2 := range folders2 {
+			if !similar[folderPairKey(folder1.Path, folder2.Path)] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// clusterTargetFolder returns the folder of target at the path of folder relative to member,
+// or target when it does not exist.
+func clusterTargetFolder(target *Folder, member string, folder string) *Folder {
+	relPath, err := filepath.Rel(member, folder)
+	if err != nil || relPath == "." {
+		return target
+	}
+
+	current := target
+	for _, name := range strings.Split(relPath, string(filepath.Separator)) {
+		next, ok := current.Folders.Load(name)
+		if !ok {
+			return target
+		}
+		current = next.(*Folder)
+	}
+	return current
+}
+
+// uniqueFileName returns name, or name with a number when a file of names already has its path in folder.
+func uniqueFileName(folder string, name string, names map[string]bool) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; names[filepath.Join(folder, candidate)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	return candidate
+}
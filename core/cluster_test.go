@@ -0,0 +1,50 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGetFolderClustersCompleteLinkage(t *testing.T) {
+	// a and d are copies, b shares half of their files and c shares the other half of b
+	folders := map[string][]int{
+		"a": {1, 2, 3, 4},
+		"d": {1, 2, 3, 4},
+		"b": {3, 4, 5, 6},
+		"c": {5, 6, 7, 8},
+	}
+	storage := NewMemoryStorage()
+	for folder, hashes := range folders {
+		for _, hash := range hashes {
+			file := &File{
+				Name: fmt.Sprintf("t%d", hash),
+				Path: fmt.Sprintf("%s/t%d", folder, hash),
+				Size: 100,
+				Hash: fmt.Sprintf("AAA%d", hash),
+			}
+			if err := storage.AddFile(file); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	checker := &SimilarityChecker{}
+	if err := checker.CalculateSimilarity(storage); err != nil {
+		t.Fatal(err)
+	}
+	defer checker.Close()
+
+	clusters := checker.GetFolderClusters(50)
+	if len(clusters) != 1 {
+		t.Fatalf("got %d clusters, want 1", len(clusters))
+	}
+	paths := []string{}
+	for _, member := range clusters[0].Members {
+		paths = append(paths, member.Path)
+		if len(member.DuplicateFiles) != member.DuplicateFileCount {
+			t.Errorf("%s: %d duplicate files listed, %d counted", member.Path, len(member.DuplicateFiles), member.DuplicateFileCount)
+		}
+	}
+	if fmt.Sprint(paths) != "[a b d]" {
+		t.Errorf("cluster members %v, want [a b d] without c, which is only similar to b", paths)
+	}
+}
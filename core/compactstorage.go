@@ -0,0 +1,699 @@
+package core
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// compactNoTime marks a record without modification time.
+const compactNoTime = math.MinInt64
+
+// compactFile is the packed record of a file.
+type compactFile struct {
+	// folder is the index of the parent folder, -1 once the file is removed.
+	folder  int32
+	name    uint32
+	size    int64
+	modTime int64
+}
+
+// compactHashKey sorts records by hash.
+type compactHashKey struct {
+	prefix uint64
+	id     int32
+}
+
+// compactFolder holds the file records of a folder.
+type compactFolder struct {
+	folder *Folder
+	files  []int32
+	loaded bool
+}
+
+// CompactStorage implements Storage with a packed in-memory representation
+// for huge trees. Files are kept as fixed-size records holding an interned name,
+// the raw hash bytes, size and modification time; paths are derived from the
+// folder. File objects are only created when a folder is first accessed, the
+// same way DiskStorage loads folders from its log.
+type CompactStorage struct {
+	*MemoryStorage
+
+	mu      sync.Mutex
+	files   []compactFile
+	folders []compactFolder
+	// folderIDs maps folders to their index in folders.
+	folderIDs map[*Folder]int32
+	// fileIDs maps the created file objects to their records.
+	fileIDs map[*File]int32
+	names   nameTable
+
+	// hashes holds hashWidth raw bytes per record.
+	hashes    []byte
+	hashWidth int
+	// rawHashes holds the hashes which are not base64 encoded hashWidth bytes.
+	rawHashes map[int32]string
+	tags      map[int32]map[string]string
+	// links holds the device and inode of hard linked files.
+	links map[int32][2]uint64
+
+	// matched is set once every folder holding a duplicated hash is loaded.
+	matched bool
+	// hashIndex holds the non-empty records sorted by hash, once built by GetMatchedFiles
+	// or loadHash, and hashTail the records added afterwards.
+	hashIndex []int32
+	hashTail  []int32
+	// frozen is set by Freeze, the maps of the folders created afterwards are frozen too.
+	frozen atomic.Bool
+}
+
+var _ Storage = &CompactStorage{}
+
+// NewCompactStorage creates a new compact storage instance.
+func NewCompactStorage() *CompactStorage {
+	return &CompactStorage{
+		MemoryStorage: NewMemoryStorage(),
+		folderIDs:     make(map[*Folder]int32),
+		fileIDs:       make(map[*File]int32),
+		rawHashes:     make(map[int32]string),
+		tags:          make(map[int32]map[string]string),
+		links:         make(map[int32][2]uint64),
+	}
+}
+
+// FileCount returns the number of files in storage.
+func (s *CompactStorage) FileCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, folder := range s.folders {
+		count += len(folder.files)
+	}
+	return count
+}
+
+// Freeze releases the memory only needed while a scan adds files: the spare
+// capacity of the records and the name interning table, and turns the maps of
+// the storage and its folders into plain maps. It must not run concurrently
+// with writes. The storage stays writable, names added afterwards are no longer interned.
+func (s *CompactStorage) Freeze() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files = slices.Clone(s.files)
+	s.hashes = slices.Clone(s.hashes)
+	for i := range s.folders {
+		s.folders[i].files = slices.Clone(s.folders[i].files)
+	}
+	s.folders = slices.Clone(s.folders)
+	s.names.freeze()
+
+	s.MemoryStorage.folders.Range(func(key, value interface{}) bool {
+		freezeFolder(value.(*Folder))
+		return true
+	})
+	s.MemoryStorage.folders.freeze()
+	s.MemoryStorage.matchedFiles.freeze()
+	s.MemoryStorage.hashMap.freeze()
+	s.frozen.Store(true)
+}
+
+// GetFolder retrieves a folder by path, creating it if it doesn't exist.
+// New folders are loaded from the packed records on first access.
+func (s *CompactStorage) GetFolder(path string) (*Folder, error) {
+	if f, ok := s.MemoryStorage.folders.Load(path); ok {
+		return f.(*Folder), nil
+	} else if path == "." || path == "/" {
+		rootFolder := &Folder{
+			Name:   path,
+			Path:   path,
+			loader: s.loadFolder,
+		}
+		if s.frozen.Load() {
+			freezeFolder(rootFolder)
+		}
+
+		f, _ := s.MemoryStorage.folders.LoadOrStore(path, rootFolder)
+		return f.(*Folder), nil
+	}
+
+	parentFolder, err := s.GetFolder(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	newFolder := &Folder{
+		Name:   filepath.Base(path),
+		Path:   path,
+		Parent: parentFolder,
+		loader: s.loadFolder,
+	}
+	if s.frozen.Load() {
+		freezeFolder(newFolder)
+	}
+
+	// Store new folder in memory storage, unless a concurrent call created it first
+	if f, loaded := s.MemoryStorage.folders.LoadOrStore(path, newFolder); loaded {
+		return f.(*Folder), nil
+	}
+	parentFolder.Folders.Store(newFolder.Name, newFolder)
+	return newFolder, nil
+}
+
+// AddFile adds a file to storage. The file object is only kept if its folder
+// was already loaded or the change is published to subscribers, otherwise it
+// is created again on first access.
+func (s *CompactStorage) AddFile(file *File) error {
+	parentFolder, err := s.GetFolder(filepath.Dir(file.Path))
+	if err != nil {
+		return err
+	}
+	if s.hasSubscribers() {
+		parentFolder.load()
+	}
+
+	s.mu.Lock()
+	id := s.folderID(parentFolder)
+	fileID, err := s.appendFile(id, file)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	loaded := s.folders[id].loaded || parentFolder.loader == nil
+	if loaded {
+		s.fileIDs[file] = fileID
+	} else {
+		s.matched = false
+	}
+	s.mu.Unlock()
+
+	if !loaded {
+		atomic.AddInt32(&parentFolder.fileCount, 1)
+		atomic.AddInt64(&parentFolder.fileSize, file.Size)
+		parentFolder.invalidateCache()
+		return nil
+	}
+
+	s.loadHash(file.Hash)
+	if err := s.MemoryStorage.addFile(file); err != nil {
+		return err
+	}
+	s.publish(ChangeEvent{Type: FileAdded, File: file, Folder: parentFolder})
+	return nil
+}
+
+// AddFolder records a folder with its metadata, creating it if it doesn't exist.
+func (s *CompactStorage) AddFolder(path string, info FolderInfo) error {
+	folder, err := s.GetFolder(path)
+	if err != nil {
+		return err
+	}
+	folder.Info = &info
+	s.publish(ChangeEvent{Type: FolderAdded, Folder: folder})
+	return nil
+}
+
+// AddFiles adds a batch of files to storage. It may be called concurrently.
+func (s *CompactStorage) AddFiles(files []*File) error {
+	errs := []error{}
+	for _, file := range files {
+		if err := s.AddFile(file); err != nil {
+			errs = append(errs, fmt.Errorf("failed to add file %s: %w", file.Path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RemoveFile removes a file from storage.
+func (s *CompactStorage) RemoveFile(file *File) error {
+	s.mu.Lock()
+	if id, ok := s.fileIDs[file]; ok {
+		s.removeRecord(id)
+		delete(s.fileIDs, file)
+	}
+	s.mu.Unlock()
+
+	parentFolder, err := s.MemoryStorage.removeFile(file)
+	if err != nil {
+		return err
+	}
+	s.publish(ChangeEvent{Type: FileRemoved, File: file, Folder: parentFolder})
+	return nil
+}
+
+// RemoveFolder removes a folder with all its files and subfolders from storage.
+func (s *CompactStorage) RemoveFolder(path string) error {
+	folder, err := s.MemoryStorage.removeFolder(path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	walkFolders(folder, func(f *Folder) {
+		f.files.Range(func(key, value interface{}) bool {
+			delete(s.fileIDs, value.(*File))
+			return true
+		})
+		id, ok := s.folderIDs[f]
+		if !ok {
+			return
+		}
+		for _, fileID := range s.folders[id].files {
+			s.files[fileID].folder = -1
+			delete(s.rawHashes, fileID)
+			delete(s.tags, fileID)
+			delete(s.links, fileID)
+		}
+		s.folders[id] = compactFolder{}
+		delete(s.folderIDs, f)
+	})
+	s.mu.Unlock()
+
+	s.publish(ChangeEvent{Type: FolderRemoved, Folder: folder, Path: path})
+	return nil
+}
+
+// MoveFolder moves a folder with all its files and subfolders into the folder dstParent.
+// The missing folders of dstParent are created as compact folders first.
+func (s *CompactStorage) MoveFolder(src string, dstParent string) error {
+	value, ok := s.MemoryStorage.folders.Load(src)
+	if !ok {
+		return fmt.Errorf("folder %s not found", src)
+	}
+	if value.(*Folder).Parent == nil {
+		return fmt.Errorf("cannot move root folder %s", src)
+	}
+	if isSubPath(dstParent, src) {
+		return fmt.Errorf("cannot move folder %s into itself", src)
+	}
+	if _, err := s.GetFolder(dstParent); err != nil {
+		return err
+	}
+	return s.MemoryStorage.MoveFolder(src, dstParent)
+}
+
+// GetMatchedFiles loads every folder holding a duplicated hash and returns the matched file groups.
+func (s *CompactStorage) GetMatchedFiles() ([]*MatchedFileGroup, error) {
+	s.mu.Lock()
+	folders := []*Folder{}
+	if !s.matched {
+		// sort the records by hash, duplicates become neighbours
+		s.buildHashIndex()
+		seen := map[int32]bool{}
+		for i := 0; i < len(s.hashIndex); {
+			j := i + 1
+			for j < len(s.hashIndex) && s.compareHash(s.hashIndex[i], s.hashIndex[j]) == 0 {
+				j++
+			}
+			if j-i > 1 {
+				for _, id := range s.hashIndex[i:j] {
+					if folder := s.files[id].folder; !seen[folder] {
+						seen[folder] = true
+						folders = append(folders, s.folders[folder].folder)
+					}
+				}
+			}
+			i = j
+		}
+		s.matched = true
+	}
+	s.mu.Unlock()
+
+	for _, folder := range folders {
+		folder.load()
+	}
+
+	return s.MemoryStorage.GetMatchedFiles()
+}
+
+// FindByHash loads the folders holding the hash and returns its non-empty files.
+func (s *CompactStorage) FindByHash(hash string) ([]*File, error) {
+	s.loadHash(hash)
+	return s.MemoryStorage.FindByHash(hash)
+}
+
+// FindBySizeRange returns all files with minSize <= size <= maxSize,
+// loading only the folders holding such files. A negative maxSize means no upper bound.
+func (s *CompactStorage) FindBySizeRange(minSize int64, maxSize int64) ([]*File, error) {
+	s.mu.Lock()
+	folders := []*Folder{}
+	for _, folder := range s.folders {
+		for _, id := range folder.files {
+			size := s.files[id].size
+			if size >= minSize && (maxSize < 0 || size <= maxSize) {
+				folders = append(folders, folder.folder)
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	result := []*File{}
+	for _, folder := range folders {
+		for _, file := range folder.GetFiles() {
+			if file.Size >= minSize && (maxSize < 0 || file.Size <= maxSize) {
+				result = append(result, file)
+			}
+		}
+	}
+	return result, nil
+}
+
+// loadFolder creates the file objects of a folder from its records.
+func (s *CompactStorage) loadFolder(folder *Folder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.folderID(folder)
+	for _, fileID := range s.folders[id].files {
+		file := s.newFile(fileID, folder)
+		folder.files.Store(file.Name, file)
+		file.Parent = folder
+		s.fileIDs[file] = fileID
+		s.MemoryStorage.addHash(file)
+	}
+	s.folders[id].loaded = true
+}
+
+// loadHash loads the folders holding files with the given hash,
+// so a newly added file is matched against them.
+func (s *CompactStorage) loadHash(hash string) {
+	s.mu.Lock()
+	raw, err := base64.RawStdEncoding.Strict().DecodeString(hash)
+	isRaw := err != nil || len(raw) != s.hashWidth
+	if isRaw {
+		raw = []byte(hash)
+	}
+	if s.hashIndex == nil || len(s.hashTail) > 1024+len(s.hashIndex)/16 {
+		s.buildHashIndex()
+	}
+
+	// the records of the hash are a run of the sorted index, the records added since are checked one by one
+	ids := []int32{}
+	start, _ := slices.BinarySearchFunc(s.hashIndex, raw, func(id int32, target []byte) int {
+		return s.compareHashBytes(id, target, isRaw)
+	})
+	for i := start; i < len(s.hashIndex) && s.compareHashBytes(s.hashIndex[i], raw, isRaw) == 0; i++ {
+		ids = append(ids, s.hashIndex[i])
+	}
+	for _, id := range s.hashTail {
+		if s.compareHashBytes(id, raw, isRaw) == 0 {
+			ids = append(ids, id)
+		}
+	}
+
+	folders := []*Folder{}
+	seen := map[int32]bool{}
+	for _, id := range ids {
+		folder := s.files[id].folder
+		if folder < 0 || s.folders[folder].loaded || seen[folder] {
+			continue
+		}
+		seen[folder] = true
+		folders = append(folders, s.folders[folder].folder)
+	}
+	s.mu.Unlock()
+
+	for _, folder := range folders {
+		folder.load()
+	}
+}
+
+// buildHashIndex sorts the non-empty records by hash, the records removed since the last build are dropped.
+func (s *CompactStorage) buildHashIndex() {
+	keys := []compactHashKey{}
+	for id, file := range s.files {
+		if file.folder >= 0 && file.size > 0 {
+			keys = append(keys, compactHashKey{prefix: s.hashPrefix(int32(id)), id: int32(id)})
+		}
+	}
+	slices.SortFunc(keys, func(a, b compactHashKey) int {
+		if c := cmp.Compare(a.prefix, b.prefix); c != 0 {
+			return c
+		}
+		return s.compareHash(a.id, b.id)
+	})
+
+	s.hashIndex = make([]int32, len(keys))
+	for i, key := range keys {
+		s.hashIndex[i] = key.id
+	}
+	s.hashTail = nil
+}
+
+// folderID returns the index of a folder, registering it on first use.
+func (s *CompactStorage) folderID(folder *Folder) int32 {
+	if id, ok := s.folderIDs[folder]; ok {
+		return id
+	}
+	id := int32(len(s.folders))
+	s.folders = append(s.folders, compactFolder{folder: folder})
+	s.folderIDs[folder] = id
+	return id
+}
+
+// appendFile packs a file into a new record of the given folder.
+func (s *CompactStorage) appendFile(folder int32, file *File) (int32, error) {
+	name, err := s.names.intern(file.Name)
+	if err != nil {
+		return 0, err
+	}
+	if len(s.files) == math.MaxInt32 {
+		return 0, fmt.Errorf("compact storage is full")
+	}
+
+	id := int32(len(s.files))
+	modTime := int64(compactNoTime)
+	if !file.ModTime.IsZero() {
+		modTime = file.ModTime.UnixNano()
+	}
+	s.files = append(s.files, compactFile{
+		folder:  folder,
+		name:    name,
+		size:    file.Size,
+		modTime: modTime,
+	})
+
+	raw, err := base64.RawStdEncoding.Strict().DecodeString(file.Hash)
+	if s.hashWidth == 0 && err == nil && len(raw) > 0 {
+		// the first encoded hash fixes the width, earlier records are raw hashes
+		s.hashWidth = len(raw)
+		s.hashes = make([]byte, int(id)*s.hashWidth)
+	}
+	if err != nil || len(raw) != s.hashWidth {
+		s.rawHashes[id] = file.Hash
+		raw = make([]byte, s.hashWidth)
+	}
+	s.hashes = append(s.hashes, raw...)
+
+	if len(file.Tags) > 0 {
+		s.tags[id] = file.Tags
+	}
+	if file.Inode != 0 {
+		s.links[id] = [2]uint64{file.Device, file.Inode}
+	}
+
+	s.folders[folder].files = append(s.folders[folder].files, id)
+	if s.hashIndex != nil && file.Size > 0 {
+		s.hashTail = append(s.hashTail, id)
+	}
+	return id, nil
+}
+
+// removeRecord removes a record from its folder.
+func (s *CompactStorage) removeRecord(id int32) {
+	folder := s.files[id].folder
+	if folder < 0 {
+		return
+	}
+	s.folders[folder].files = slices.DeleteFunc(s.folders[folder].files, func(fileID int32) bool {
+		return fileID == id
+	})
+	s.files[id].folder = -1
+	delete(s.rawHashes, id)
+	delete(s.tags, id)
+	delete(s.links, id)
+}
+
+// newFile creates the file object of a record.
+func (s *CompactStorage) newFile(id int32, folder *Folder) *File {
+	record := s.files[id]
+	name := s.names.get(record.name)
+	file := &File{
+		Name: name,
+		Path: filepath.Join(folder.Path, name),
+		Size: record.size,
+		Tags: s.tags[id],
+	}
+	if link, ok := s.links[id]; ok {
+		file.Device, file.Inode = link[0], link[1]
+	}
+	if record.modTime != compactNoTime {
+		file.ModTime = time.Unix(0, record.modTime)
+	}
+	if hash, isRaw := s.hashBytes(id); isRaw {
+		file.Hash = string(hash)
+	} else {
+		file.Hash = base64.RawStdEncoding.EncodeToString(hash)
+	}
+	return file
+}
+
+// hashBytes returns the stored hash of a record and whether it is kept as a string.
+func (s *CompactStorage) hashBytes(id int32) ([]byte, bool) {
+	if hash, ok := s.rawHashes[id]; ok {
+		return []byte(hash), true
+	}
+	return s.hashes[int(id)*s.hashWidth : int(id+1)*s.hashWidth], false
+}
+
+// hashPrefix returns the first 8 bytes of the hash of a record for sorting, string hashes last.
+func (s *CompactStorage) hashPrefix(id int32) uint64 {
+	hash, isRaw := s.hashBytes(id)
+	if isRaw {
+		return math.MaxUint64
+	}
+	var prefix [8]byte
+	copy(prefix[:], hash)
+	return binary.BigEndian.Uint64(prefix[:])
+}
+
+// compareHash orders two records by hash, string hashes last.
+func (s *CompactStorage) compareHash(a int32, b int32) int {
+	hashA, rawA := s.hashBytes(a)
+	hashB, rawB := s.hashBytes(b)
+	if rawA != rawB {
+		if rawA {
+			return 1
+		}
+		return -1
+	}
+	return bytes.Compare(hashA, hashB)
+}
+
+// compareHashBytes orders the hash of a record and a hash given as raw bytes,
+// or as a string when isRaw is set, string hashes last.
+func (s *CompactStorage) compareHashBytes(id int32, hash []byte, isRaw bool) int {
+	stored, storedRaw := s.hashBytes(id)
+	if storedRaw != isRaw {
+		if storedRaw {
+			return 1
+		}
+		return -1
+	}
+	return bytes.Compare(stored, hash)
+}
+
+// freezeFolder turns the maps of a folder into plain maps.
+func freezeFolder(folder *Folder) {
+	folder.Folders.freeze()
+	folder.files.freeze()
+}
+
+// nameTable interns names into a single byte arena, indexed by an open addressing hash table.
+type nameTable struct {
+	data    []byte
+	offsets []uint32
+	// slots holds name index + 1 per slot, 0 when empty; nil once frozen.
+	slots []uint32
+	count int
+	// frozen disables interning of new names.
+	frozen bool
+}
+
+// intern returns the index of name, adding it if needed.
+func (t *nameTable) intern(name string) (uint32, error) {
+	if len(t.data)+len(name) > math.MaxUint32 {
+		return 0, fmt.Errorf("compact storage name table is full")
+	}
+	if t.frozen {
+		return t.add(name), nil
+	}
+
+	if (t.count+1)*4 > len(t.slots)*3 {
+		t.grow()
+	}
+	mask := uint64(len(t.slots) - 1)
+	for slot := nameHash(name) & mask; ; slot = (slot + 1) & mask {
+		id := t.slots[slot]
+		if id == 0 {
+			index := t.add(name)
+			t.slots[slot] = index + 1
+			t.count++
+			return index, nil
+		}
+		if t.equal(id-1, name) {
+			return id - 1, nil
+		}
+	}
+}
+
+// add appends name to the arena and returns its index.
+func (t *nameTable) add(name string) uint32 {
+	if len(t.offsets) == 0 {
+		t.offsets = append(t.offsets, 0)
+	}
+	t.data = append(t.data, name...)
+	t.offsets = append(t.offsets, uint32(len(t.data)))
+	return uint32(len(t.offsets) - 2)
+}
+
+// get returns the name at index.
+func (t *nameTable) get(index uint32) string {
+	return string(t.bytes(index))
+}
+
+// equal reports whether the name at index is name, without allocating.
+func (t *nameTable) equal(index uint32, name string) bool {
+	return string(t.bytes(index)) == name
+}
+
+func (t *nameTable) bytes(index uint32) []byte {
+	return t.data[t.offsets[index]:t.offsets[index+1]]
+}
+
+// grow doubles the hash table and reinserts every interned name.
+func (t *nameTable) grow() {
+	size := max(len(t.slots)*2, 1024)
+	slots := make([]uint32, size)
+	mask := uint64(size - 1)
+	for _, id := range t.slots {
+		if id == 0 {
+			continue
+		}
+		slot := nameHash(t.bytes(id-1)) & mask
+		for slots[slot] != 0 {
+			slot = (slot + 1) & mask
+		}
+		slots[slot] = id
+	}
+	t.slots = slots
+}
+
+// freeze drops the hash table and the spare capacity of the arena.
+func (t *nameTable) freeze() {
+	t.data = slices.Clone(t.data)
+	t.offsets = slices.Clone(t.offsets)
+	t.slots = nil
+	t.frozen = true
+}
+
+// nameHash returns the FNV-1a hash of name.
+func nameHash[T string | []byte](name T) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(name); i++ {
+		h ^= uint64(name[i])
+		h *= 1099511628211
+	}
+	return h
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+var benchFiles = flag.Int("bench-files", 5_000_000, "number of files of BenchmarkCompactStorageMemory")
+
+// syntheticTree calls add for the files of a tree of files files, 100 files per folder,
+// where one folder in 20 is a copy of the previous one.
+func syntheticTree(files int, add func(file *File) error) error {
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	raw := make([]byte, 16)
+	for i := 0; i < files; i++ {
+		folder, index := i/100, i%100
+		source := folder
+		if folder%20 == 19 {
+			source = folder - 1
+		}
+		binary.BigEndian.PutUint64(raw, uint64(source*100+index)*0x9e3779b97f4a7c15)
+		binary.BigEndian.PutUint64(raw[8:], uint64(source*100+index))
+		name := fmt.Sprintf("track %02d.flac", index)
+		file := &File{
+			Name:    name,
+			Path:    fmt.Sprintf("root/artist %d/album %d/%s", folder/10, folder, name),
+			Size:    int64(1000 + index),
+			Hash:    base64.RawStdEncoding.EncodeToString(raw),
+			ModTime: modTime,
+		}
+		if err := add(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// heapAlloc returns the live heap after a garbage collection.
+func heapAlloc() uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// BenchmarkCompactStorageMemory reports the heap and the bytes per file held by the compact storage,
+// next to the memory storage, for the synthetic tree of the Readme. Run it with -benchtime=1x, and
+// -bench-files to change the size of the tree.
+func BenchmarkCompactStorageMemory(b *testing.B) {
+	storages := []struct {
+		name   string
+		create func() Storage
+	}{
+		{"memory", func() Storage { return NewMemoryStorage() }},
+		{"compact", func() Storage { return NewCompactStorage() }},
+	}
+	for _, test := range storages {
+		b.Run(test.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				before := heapAlloc()
+				storage := test.create()
+				if err := syntheticTree(*benchFiles, storage.AddFile); err != nil {
+					b.Fatal(err)
+				}
+				if compact, ok := storage.(*CompactStorage); ok {
+					compact.Freeze()
+				}
+				loaded := heapAlloc() - before
+				if _, err := storage.GetMatchedFiles(); err != nil {
+					b.Fatal(err)
+				}
+				matched := heapAlloc() - before
+
+				b.ReportMetric(float64(loaded)/(1<<20), "MiB")
+				b.ReportMetric(float64(loaded)/float64(*benchFiles), "B/file")
+				b.ReportMetric(float64(matched)/(1<<20), "MiB-matched")
+				runtime.KeepAlive(storage)
+			}
+		})
+	}
+}
+
+func TestCompactStorageMoveFolder(t *testing.T) {
+	storage := NewCompactStorage()
+	if err := syntheticTree(400, storage.AddFile); err != nil {
+		t.Fatal(err)
+	}
+	storage.Freeze()
+
+	if err := storage.MoveFolder("root/artist 0/album 1", "root/new/artist"); err != nil {
+		t.Fatal(err)
+	}
+	folder, err := storage.GetFolder("root/new/artist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if folder.loader == nil {
+		t.Errorf("destination folder has no compact loader")
+	}
+	moved, err := storage.GetFolder("root/new/artist/album 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(moved.GetFiles()); got != 100 {
+		t.Errorf("moved folder holds %d files, want 100", got)
+	}
+
+	// a file added after the move is matched against the moved records
+	file := *moved.GetFiles()[0]
+	file.Name = "copy.flac"
+	file.Path = "root/new/artist/copy.flac"
+	if err := storage.AddFile(&file); err != nil {
+		t.Fatal(err)
+	}
+	files, err := storage.FindByHash(file.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("found %d files with the hash, want 2", len(files))
+	}
+}
+
+func TestCompactStorageFindByHash(t *testing.T) {
+	storage := NewCompactStorage()
+	if err := syntheticTree(2000, storage.AddFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.GetMatchedFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	// records added after the index is built are found in its tail
+	extra := &File{Name: "extra", Path: "root/extra/extra", Size: 1, Hash: "/////////////////////w"}
+	if err := storage.AddFile(extra); err != nil {
+		t.Fatal(err)
+	}
+	reference := NewMemoryStorage()
+	if err := syntheticTree(2000, reference.AddFile); err != nil {
+		t.Fatal(err)
+	}
+	for _, folder := range []string{"root/artist 1/album 12", "root/artist 1/album 19"} {
+		value, _ := reference.folders.Load(folder)
+		for _, file := range value.(*Folder).GetFiles() {
+			want, _ := reference.FindByHash(file.Hash)
+			got, err := storage.FindByHash(file.Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(want) {
+				t.Errorf("%s: found %d files, want %d", file.Path, len(got), len(want))
+			}
+		}
+	}
+	if got, _ := storage.FindByHash(extra.Hash); len(got) != 1 {
+		t.Errorf("found %d files with the added hash, want 1", len(got))
+	}
+}
@@ -0,0 +1,449 @@
+package core
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DatabaseVersion is the version of the database format written by ExportStorage.
+// Version 0 is the legacy bare JSON array of files, version 1 a single JSON
+// envelope, version 2 newline delimited JSON and version 3 adds folder lines.
+const DatabaseVersion = 3
+
+var (
+	ErrRootMismatch    = errors.New("database was created for a different root path")
+	ErrHasherMismatch  = errors.New("database was created with a different hasher")
+	ErrUnknownDatabase = errors.New("unsupported database version")
+	ErrFingerprint     = errors.New("files under the root do not match the database")
+)
+
+// fingerprintSize is the number of files sampled by NewFingerprint.
+const fingerprintSize = 8
+
+// DatabaseHeader describes how the files of a database were scanned.
+type DatabaseHeader struct {
+	Version  int
+	Roots    []string
+	Hasher   string
+	ScanTime time.Time
+	Options  map[string]string `json:",omitempty"`
+	// Fingerprint samples files of the root to recognize it at another location.
+	Fingerprint []FingerprintEntry `json:",omitempty"`
+}
+
+// FingerprintEntry records a sampled file relative to the root.
+type FingerprintEntry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// RootRemap relocates the root From recorded in a database to To.
+type RootRemap struct {
+	// Label selects the mounted database relocated. Without label, the remap
+	// relocates the mounted databases whose root is below From.
+	Label string
+	From  string
+	To    string
+}
+
+// ParseRootRemap parses a remap given as old=new, or as label:old=new for a mounted database.
+func ParseRootRemap(value string) (RootRemap, error) {
+	remap := RootRemap{}
+	spec := value
+	if label, rest, ok := strings.Cut(value, ":"); ok && label != "" && !strings.ContainsAny(label, "=/"+string(filepath.Separator)) {
+		remap.Label, spec = label, rest
+	}
+	from, to, ok := strings.Cut(spec, "=")
+	if !ok || from == "" || to == "" {
+		return RootRemap{}, fmt.Errorf("invalid remap %q, expected old=new or label:old=new", value)
+	}
+	remap.From, remap.To = from, to
+	return remap, nil
+}
+
+// ParseRootRemaps parses remaps separated by commas.
+func ParseRootRemaps(value string) ([]RootRemap, error) {
+	remaps := []RootRemap{}
+	if value == "" {
+		return remaps, nil
+	}
+	for _, spec := range strings.Split(value, ",") {
+		remap, err := ParseRootRemap(spec)
+		if err != nil {
+			return nil, err
+		}
+		remaps = append(remaps, remap)
+	}
+	return remaps, nil
+}
+
+// matches reports whether a root of roots is below the remapped root.
+func (r RootRemap) matches(roots []string) bool {
+	from, err := filepath.Abs(r.From)
+	if err != nil {
+		return false
+	}
+	for _, root := range roots {
+		if isSubPath(root, from) {
+			return true
+		}
+	}
+	return false
+}
+
+// folderEntry is a folder of the database with its metadata.
+type folderEntry struct {
+	Path string
+	FolderInfo
+}
+
+// databaseEntry is a line of the database after the header: a file,
+// or a folder written as {"Folder": {...}}.
+type databaseEntry struct {
+	File
+	Folder *folderEntry `json:",omitempty"`
+}
+
+// Database is the version 1 envelope; newer versions stream the header and files separately.
+type Database struct {
+	DatabaseHeader
+	Files []File
+}
+
+// Check verifies the database was created for the given root path and hasher.
+// Legacy databases without a recorded root skip the root check.
+func (h *DatabaseHeader) Check(root string, hasher string) error {
+	if root != "" && len(h.Roots) > 0 {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(h.Roots, absRoot) {
+			return fmt.Errorf("%w: database root %v, given %s (use -remap %s=%s if it was moved)", ErrRootMismatch, h.Roots, absRoot, h.Roots[0], absRoot)
+		}
+	}
+	if hasher != "" && h.Hasher != "" && h.Hasher != hasher {
+		return fmt.Errorf("%w: database hasher %s, given %s (use -hash %s)", ErrHasherMismatch, h.Hasher, hasher, h.Hasher)
+	}
+	return nil
+}
+
+// Remap replaces the recorded roots at or below From with the same path below To.
+func (h *DatabaseHeader) Remap(remaps ...RootRemap) error {
+	for _, remap := range remaps {
+		from, err := filepath.Abs(remap.From)
+		if err != nil {
+			return err
+		}
+		to, err := filepath.Abs(remap.To)
+		if err != nil {
+			return err
+		}
+
+		found := false
+		for i, root := range h.Roots {
+			if isSubPath(root, from) {
+				h.Roots[i] = filepath.Join(to, root[len(from):])
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: database root %v is not below %s", ErrRootMismatch, h.Roots, from)
+		}
+	}
+	return nil
+}
+
+// VerifyFingerprint checks the sampled files exist under root with the recorded
+// size and modification time. At least half of them must match, so files changed
+// since the database was saved do not prevent loading it.
+func (h *DatabaseHeader) VerifyFingerprint(root string) error {
+	if len(h.Fingerprint) == 0 {
+		return nil
+	}
+
+	matched := 0
+	for _, entry := range h.Fingerprint {
+		info, err := os.Stat(filepath.Join(root, entry.Path))
+		if err != nil || info.Size() != entry.Size {
+			continue
+		}
+		// allow for file systems with a coarse modification time
+		if diff := info.ModTime().Sub(entry.ModTime); diff > -2*time.Second && diff < 2*time.Second {
+			matched++
+		}
+	}
+	if matched*2 < len(h.Fingerprint) {
+		return fmt.Errorf("%w: %d of %d sampled files found under %s", ErrFingerprint, matched, len(h.Fingerprint), root)
+	}
+	return nil
+}
+
+// NewFingerprint samples up to fingerprintSize files of storage. The sample is
+// chosen by path hash, so the same files are picked independent of walk order.
+func NewFingerprint(storage Storage) []FingerprintEntry {
+	type sample struct {
+		hash uint64
+		file *File
+	}
+	samples := []sample{}
+	for file := range storage.Walk(".") {
+		hash := nameHash(file.Path)
+		if len(samples) == fingerprintSize && hash >= samples[len(samples)-1].hash {
+			continue
+		}
+		index := sort.Search(len(samples), func(i int) bool {
+			return samples[i].hash > hash
+		})
+		samples = slices.Insert(samples, index, sample{hash: hash, file: file})
+		if len(samples) > fingerprintSize {
+			samples = samples[:fingerprintSize]
+		}
+	}
+
+	entries := []FingerprintEntry{}
+	for _, sample := range samples {
+		entries = append(entries, FingerprintEntry{
+			Path:    sample.file.Path,
+			Size:    sample.file.Size,
+			ModTime: sample.file.ModTime,
+		})
+	}
+	return entries
+}
+
+// ExportStorage streams every file in storage to w as newline delimited JSON:
+// the header on the first line followed by one line per folder with metadata,
+// parents first, and one file per line. The fingerprint of the header is
+// sampled from the exported files.
+func ExportStorage(w io.Writer, storage Storage, header DatabaseHeader) error {
+	header.Version = DatabaseVersion
+	header.Fingerprint = NewFingerprint(storage)
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(header); err != nil {
+		return err
+	}
+
+	root, err := storage.GetFolder(".")
+	if err != nil {
+		return err
+	}
+	walkFolders(root, func(folder *Folder) {
+		if folder.Info == nil || err != nil {
+			return
+		}
+		err = encoder.Encode(struct{ Folder folderEntry }{folderEntry{Path: folder.Path, FolderInfo: *folder.Info}})
+	})
+	if err != nil {
+		return err
+	}
+
+	for file := range storage.Walk(".") {
+		if err := encoder.Encode(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportStorage reads a database from r, adds its files to storage and returns its header.
+// Gzip compressed input is detected by its magic bytes. The legacy bare array and
+// single JSON envelope formats are migrated transparently; root and hasher are
+// checked against the header when given, after relocating its roots by remaps.
+func ImportStorage(r io.Reader, storage Storage, root string, hasher string, remaps ...RootRemap) (*DatabaseHeader, error) {
+	reader := bufio.NewReader(r)
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = bufio.NewReader(gzipReader)
+	}
+
+	first, err := peekNonSpace(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(reader)
+	var db Database
+
+	if first == '[' {
+		// legacy format: bare array of files, hashed with imohash
+		db.Version = 0
+		db.Hasher = HasherImohash
+		if err := db.Remap(remaps...); err != nil {
+			return nil, err
+		}
+		if err := db.Check(root, hasher); err != nil {
+			return nil, err
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		for decoder.More() {
+			if err := importFile(decoder, storage); err != nil {
+				return nil, err
+			}
+		}
+		return &db.DatabaseHeader, nil
+	}
+
+	// the first object is the header, or the whole database for version 1
+	if err := decoder.Decode(&db); err != nil {
+		return nil, err
+	}
+	if db.Version < 1 || db.Version > DatabaseVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownDatabase, db.Version)
+	}
+	if err := db.Remap(remaps...); err != nil {
+		return nil, err
+	}
+	if err := db.Check(root, hasher); err != nil {
+		return nil, err
+	}
+
+	for i := range db.Files {
+		file := &db.Files[i]
+		file.Parent = nil
+		if err := storage.AddFile(file); err != nil {
+			return nil, fmt.Errorf("failed to import file %s: %w", file.Path, err)
+		}
+	}
+	for decoder.More() {
+		if err := importFile(decoder, storage); err != nil {
+			return nil, err
+		}
+	}
+
+	return &db.DatabaseHeader, nil
+}
+
+// SaveDatabase exports storage to the file at path, gzip compressed when the path ends with .gz
+// or the database replaced is gzip compressed. The database is written to a temporary file next
+// to path which replaces it once complete, so a failed save leaves the previous database intact.
+func SaveDatabase(path string, storage Storage, header DatabaseHeader) (err error) {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	// keep the permissions of the database replaced
+	mode := os.FileMode(0o644)
+	if info, statErr := os.Stat(path); statErr == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := file.Chmod(mode); err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	var w io.Writer = writer
+	var gzipWriter *gzip.Writer
+	if strings.HasSuffix(path, ".gz") || isGzipFile(path) {
+		gzipWriter = gzip.NewWriter(writer)
+		w = gzipWriter
+	}
+
+	if err := ExportStorage(w, storage, header); err != nil {
+		return err
+	}
+	if gzipWriter != nil {
+		if err := gzipWriter.Close(); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// isGzipFile reports whether the file at path starts with the gzip magic bytes.
+func isGzipFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return false
+	}
+	return magic[0] == 0x1f && magic[1] == 0x8b
+}
+
+// LoadDatabase imports the database file at path into storage.
+func LoadDatabase(path string, storage Storage, root string, hasher string, remaps ...RootRemap) (*DatabaseHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, err := ImportStorage(file, storage, root, hasher, remaps...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	return header, nil
+}
+
+// importFile decodes the next file or folder from decoder and adds it to storage.
+func importFile(decoder *json.Decoder, storage Storage) error {
+	entry := &databaseEntry{}
+	if err := decoder.Decode(entry); err != nil {
+		return err
+	}
+	if entry.Folder != nil {
+		if err := storage.AddFolder(entry.Folder.Path, entry.Folder.FolderInfo); err != nil {
+			return fmt.Errorf("failed to import folder %s: %w", entry.Folder.Path, err)
+		}
+		return nil
+	}
+
+	file := &entry.File
+	file.Parent = nil
+	if err := storage.AddFile(file); err != nil {
+		return fmt.Errorf("failed to import file %s: %w", file.Path, err)
+	}
+	return nil
+}
+
+// peekNonSpace skips leading white space and returns the next byte without consuming it.
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\n' && b[0] != '\r' {
+			return b[0], nil
+		}
+		reader.ReadByte()
+	}
+}
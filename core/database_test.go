@@ -0,0 +1,97 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failingStorage fails the export of a database.
+type failingStorage struct {
+	*MemoryStorage
+}
+
+func (s failingStorage) GetFolder(path string) (*Folder, error) {
+	return nil, errors.New("storage failed")
+}
+
+func TestSaveDatabaseKeepsPreviousOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db.json.gz")
+
+	storage := NewMemoryStorage()
+	for _, name := range []string{"a", "b"} {
+		if err := storage.AddFile(&File{Name: name, Path: "music/" + name, Size: 10, Hash: "AAAA"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SaveDatabase(path, storage, DatabaseHeader{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveDatabase(path, storage, DatabaseHeader{}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("saved database mode %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+
+	if err := SaveDatabase(path, failingStorage{NewMemoryStorage()}, DatabaseHeader{}); err == nil {
+		t.Fatal("saving a failing storage succeeded")
+	}
+
+	loaded := NewMemoryStorage()
+	if _, err := LoadDatabase(path, loaded, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := loaded.FindByHash("AAAA"); len(files) != 2 {
+		t.Errorf("loaded %d files, want 2", len(files))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d files left in the database folder, want 1", len(entries))
+	}
+}
+
+func TestSaveDatabaseKeepsCompression(t *testing.T) {
+	dir := t.TempDir()
+	storage := NewMemoryStorage()
+	if err := storage.AddFile(&File{Name: "a", Path: "music/a", Size: 10, Hash: "AAAA"}); err != nil {
+		t.Fatal(err)
+	}
+
+	compressed := filepath.Join(dir, "compressed.gz")
+	if err := SaveDatabase(compressed, storage, DatabaseHeader{}); err != nil {
+		t.Fatal(err)
+	}
+	// a compressed database renamed without the suffix stays compressed
+	renamed := filepath.Join(dir, "renamed.json")
+	if err := os.Rename(compressed, renamed); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "plain.json")
+	for _, path := range []string{renamed, plain} {
+		if err := SaveDatabase(path, storage, DatabaseHeader{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for path, want := range map[string]bool{renamed: true, plain: false} {
+		if got := isGzipFile(path); got != want {
+			t.Errorf("%s saved compressed %v, want %v", filepath.Base(path), got, want)
+		}
+		loaded := NewMemoryStorage()
+		if _, err := LoadDatabase(path, loaded, "", ""); err != nil {
+			t.Fatal(err)
+		}
+		if files, _ := loaded.FindByHash("AAAA"); len(files) != 1 {
+			t.Errorf("%s: loaded %d files, want 1", filepath.Base(path), len(files))
+		}
+	}
+}
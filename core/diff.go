@@ -0,0 +1,202 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// FileChange represents a file present in both snapshots with a different path or hash.
+type FileChange struct {
+	Old *File
+	New *File
+}
+
+// FolderGrowth represents the change of the total file size of a folder between two snapshots.
+type FolderGrowth struct {
+	Path    string
+	OldSize int64
+	NewSize int64
+	Delta   int64
+}
+
+// SnapshotDiff represents the changes between two snapshots of the same tree.
+type SnapshotDiff struct {
+	Added    []*File
+	Removed  []*File
+	Modified []FileChange
+	Moved    []FileChange
+	Folders  []FolderGrowth
+}
+
+// DiffStorage compares two snapshots using the stored paths and hashes only.
+// Files with the same path and a new hash are modified; a removed file whose
+// hash reappears at an added path is moved.
+func DiffStorage(oldStorage Storage, newStorage Storage) *SnapshotDiff {
+	diff := &SnapshotDiff{
+		Added:    []*File{},
+		Removed:  []*File{},
+		Modified: []FileChange{},
+		Moved:    []FileChange{},
+		Folders:  []FolderGrowth{},
+	}
+
+	oldFiles := map[string]*File{}
+	for file := range oldStorage.Walk(".") {
+		oldFiles[file.Path] = file
+	}
+	newFiles := map[string]*File{}
+	for file := range newStorage.Walk(".") {
+		newFiles[file.Path] = file
+	}
+
+	// added files by hash, candidates for moved files
+	added := map[string][]*File{}
+	for path, file := range newFiles {
+		oldFile, ok := oldFiles[path]
+		if !ok {
+			added[file.Hash] = append(added[file.Hash], file)
+		} else if oldFile.Hash != file.Hash || oldFile.Size != file.Size {
+			diff.Modified = append(diff.Modified, FileChange{Old: oldFile, New: file})
+		}
+	}
+	for _, files := range added {
+		sortFilesByPath(files)
+	}
+
+	removed := []*File{}
+	for path, file := range oldFiles {
+		if _, ok := newFiles[path]; !ok {
+			removed = append(removed, file)
+		}
+	}
+	sortFilesByPath(removed)
+
+	for _, file := range removed {
+		candidates := added[file.Hash]
+		if file.Size == 0 || len(candidates) == 0 {
+			diff.Removed = append(diff.Removed, file)
+			continue
+		}
+
+		// prefer a candidate keeping the file name
+		index := 0
+		for i, candidate := range candidates {
+			if candidate.Name == file.Name {
+				index = i
+				break
+			}
+		}
+		diff.Moved = append(diff.Moved, FileChange{Old: file, New: candidates[index]})
+		added[file.Hash] = append(candidates[:index], candidates[index+1:]...)
+	}
+	for _, files := range added {
+		diff.Added = append(diff.Added, files...)
+	}
+
+	sortFilesByPath(diff.Added)
+	sort.Slice(diff.Modified, func(i, j int) bool {
+		return diff.Modified[i].New.Path < diff.Modified[j].New.Path
+	})
+	sort.Slice(diff.Moved, func(i, j int) bool {
+		return diff.Moved[i].Old.Path < diff.Moved[j].Old.Path
+	})
+
+	diff.Folders = folderGrowth(oldFiles, newFiles)
+	return diff
+}
+
+// folderGrowth sums the file sizes of every folder in both snapshots and returns the
+// folders whose size changed, largest change first.
+func folderGrowth(oldFiles map[string]*File, newFiles map[string]*File) []FolderGrowth {
+	sizes := map[string]*FolderGrowth{}
+	add := func(path string, size int64, isNew bool) {
+		for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+			growth, ok := sizes[dir]
+			if !ok {
+				growth = &FolderGrowth{Path: dir}
+				sizes[dir] = growth
+			}
+			if isNew {
+				growth.NewSize += size
+			} else {
+				growth.OldSize += size
+			}
+			if dir == "." || dir == "/" {
+				break
+			}
+		}
+	}
+	for path, file := range oldFiles {
+		add(path, file.Size, false)
+	}
+	for path, file := range newFiles {
+		add(path, file.Size, true)
+	}
+
+	output := []FolderGrowth{}
+	for _, growth := range sizes {
+		growth.Delta = growth.NewSize - growth.OldSize
+		if growth.Delta != 0 {
+			output = append(output, *growth)
+		}
+	}
+	sort.Slice(output, func(i, j int) bool {
+		di, dj := abs(output[i].Delta), abs(output[j].Delta)
+		if di == dj {
+			return output[i].Path < output[j].Path
+		}
+		return di > dj
+	})
+	return output
+}
+
+// WriteText writes a human-readable summary of the diff.
+func (d *SnapshotDiff) WriteText(w io.Writer) error {
+	lines := []string{}
+	lines = append(lines, fmt.Sprintf("Added (%d):", len(d.Added)))
+	for _, file := range d.Added {
+		lines = append(lines, fmt.Sprintf("  + %s (%s)", file.Path, FormatFileSize(file.Size)))
+	}
+	lines = append(lines, fmt.Sprintf("Removed (%d):", len(d.Removed)))
+	for _, file := range d.Removed {
+		lines = append(lines, fmt.Sprintf("  - %s (%s)", file.Path, FormatFileSize(file.Size)))
+	}
+	lines = append(lines, fmt.Sprintf("Modified (%d):", len(d.Modified)))
+	for _, change := range d.Modified {
+		lines = append(lines, fmt.Sprintf("  ~ %s (%s -> %s)", change.New.Path, FormatFileSize(change.Old.Size), FormatFileSize(change.New.Size)))
+	}
+	lines = append(lines, fmt.Sprintf("Moved (%d):", len(d.Moved)))
+	for _, change := range d.Moved {
+		lines = append(lines, fmt.Sprintf("  > %s -> %s", change.Old.Path, change.New.Path))
+	}
+	lines = append(lines, fmt.Sprintf("Folder growth (%d):", len(d.Folders)))
+	for _, growth := range d.Folders {
+		sign := "+"
+		if growth.Delta < 0 {
+			sign = "-"
+		}
+		lines = append(lines, fmt.Sprintf("  %s%s %s (%s -> %s)", sign, FormatFileSize(abs(growth.Delta)), growth.Path, FormatFileSize(growth.OldSize), FormatFileSize(growth.NewSize)))
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortFilesByPath(files []*File) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// diffStorage returns a storage with a file for every "hash:size:path".
+func diffStorage(t *testing.T, specs ...string) *MemoryStorage {
+	t.Helper()
+	storage := NewMemoryStorage()
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 3)
+		var size int64
+		fmt.Sscan(parts[1], &size)
+		file := &File{Name: filepath.Base(parts[2]), Path: parts[2], Size: size, Hash: parts[0]}
+		if err := storage.AddFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return storage
+}
+
+func TestDiffStorage(t *testing.T) {
+	oldStorage := diffStorage(t,
+		"AAAA:100:music/album/01.flac",
+		"BBBB:200:music/album/02.flac",
+		"CCCC:300:music/single.flac",
+		"DDDD:50:docs/notes.txt",
+		"EEEE:40:docs/todo.txt",
+		"FFFF:10:docs/old.txt",
+		"ZERO:0:docs/empty",
+	)
+	newStorage := diffStorage(t,
+		// unchanged
+		"AAAA:100:music/album/01.flac",
+		// moved, keeping its name over the copy
+		"BBBB:200:archive/copy.flac",
+		"BBBB:200:archive/02.flac",
+		// moved and changed in the same run
+		"CCC2:350:archive/single.flac",
+		// modified in place, with the same size
+		"DDD2:50:docs/notes.txt",
+		// modified in place
+		"EEEE:45:docs/todo.txt",
+		// an empty file is never moved
+		"ZERO:0:docs/empty2",
+		"GGGG:20:docs/new.txt",
+	)
+
+	diff := DiffStorage(oldStorage, newStorage)
+	describe := func(files []*File) string {
+		paths := []string{}
+		for _, file := range files {
+			paths = append(paths, file.Path)
+		}
+		return strings.Join(paths, ",")
+	}
+	describeChanges := func(changes []FileChange) string {
+		paths := []string{}
+		for _, change := range changes {
+			paths = append(paths, change.Old.Path+">"+change.New.Path)
+		}
+		return strings.Join(paths, ",")
+	}
+
+	if got, want := describe(diff.Added), "archive/copy.flac,archive/single.flac,docs/empty2,docs/new.txt"; got != want {
+		t.Errorf("added %s, want %s", got, want)
+	}
+	if got, want := describe(diff.Removed), "docs/empty,docs/old.txt,music/single.flac"; got != want {
+		t.Errorf("removed %s, want %s", got, want)
+	}
+	if got, want := describeChanges(diff.Modified), "docs/notes.txt>docs/notes.txt,docs/todo.txt>docs/todo.txt"; got != want {
+		t.Errorf("modified %s, want %s", got, want)
+	}
+	if got, want := describeChanges(diff.Moved), "music/album/02.flac>archive/02.flac"; got != want {
+		t.Errorf("moved %s, want %s", got, want)
+	}
+
+	folders := []string{}
+	for _, growth := range diff.Folders {
+		if growth.Delta != growth.NewSize-growth.OldSize {
+			t.Errorf("%s grew by %d from %d to %d", growth.Path, growth.Delta, growth.OldSize, growth.NewSize)
+		}
+		folders = append(folders, fmt.Sprintf("%s %d>%d", growth.Path, growth.OldSize, growth.NewSize))
+	}
+	// largest change first
+	want := "archive 0>750,music 600>100,. 700>965,music/album 300>100,docs 100>115"
+	if got := strings.Join(folders, ","); got != want {
+		t.Errorf("folder growth %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,812 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// diskMagic identifies a DiskStorage database file.
+const diskMagic = "DEDUPDB\x01"
+
+// Record types of the DiskStorage log.
+const (
+	diskRecordAdd          byte = 1
+	diskRecordRemove       byte = 2
+	diskRecordHeader       byte = 3
+	diskRecordRemoveFolder byte = 4
+	diskRecordMoveFolder   byte = 5
+	diskRecordFolder       byte = 6
+)
+
+// diskMaxRecord is the largest record payload, a longer record is corrupted.
+const diskMaxRecord = 16 << 20
+
+// errDiskCorrupted reports a record which cannot have been written by DiskStorage.
+var errDiskCorrupted = errors.New("corrupted database")
+
+// diskEntry is the in-memory index entry of a file record.
+type diskEntry struct {
+	offset int64
+	hash   string
+	size   int64
+}
+
+// DiskStorage implements Storage on top of a single append-only log file.
+// Only a compact index is kept in memory; folder contents are read from the
+// log when a folder is first accessed.
+type DiskStorage struct {
+	*MemoryStorage
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	end    int64
+
+	// entries indexes the live file records by folder path and file name.
+	entries map[string]map[string]diskEntry
+	// hashes counts the live non-empty files per hash, and hashFolders per hash and folder path.
+	hashes      map[string]int
+	hashFolders map[string]map[string]int
+	// folderInfos indexes the metadata of the recorded folders by path.
+	folderInfos map[string]FolderInfo
+	// header is the last database header written to the log.
+	header *DatabaseHeader
+	// loadErr is the first error met while loading a folder from the log.
+	loadErr error
+}
+
+var _ Storage = &DiskStorage{}
+
+// OpenDiskStorage opens the database at path, creating it if it does not exist.
+func OpenDiskStorage(path string) (*DiskStorage, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+
+	s := &DiskStorage{
+		MemoryStorage: NewMemoryStorage(),
+		file:          file,
+		entries:       make(map[string]map[string]diskEntry),
+		hashes:        make(map[string]int),
+		hashFolders:   make(map[string]map[string]int),
+		folderInfos:   make(map[string]FolderInfo),
+	}
+
+	if err := s.readIndex(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read database %s: %w", path, err)
+	}
+
+	// drop a record truncated by an interrupted write
+	if err := file.Truncate(s.end); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(s.end, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.writer = bufio.NewWriter(file)
+
+	// create the folder skeleton, files are loaded on first access
+	for path, files := range s.entries {
+		folder, err := s.MemoryStorage.GetFolder(path)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		atomic.StoreInt32(&folder.fileCount, int32(len(files)))
+		size := int64(0)
+		for _, entry := range files {
+			size += entry.size
+		}
+		atomic.StoreInt64(&folder.fileSize, size)
+		folder.loader = s.loadFolder
+	}
+	for path, info := range s.folderInfos {
+		folder, err := s.MemoryStorage.GetFolder(path)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		folder.Info = &info
+	}
+
+	return s, nil
+}
+
+// FileCount returns the number of files in the database.
+func (s *DiskStorage) FileCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, files := range s.entries {
+		count += len(files)
+	}
+	return count
+}
+
+// Header returns the database header, or nil if none was recorded.
+func (s *DiskStorage) Header() *DatabaseHeader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.header
+}
+
+// SetHeader records the database header in the log.
+func (s *DiskStorage) SetHeader(header DatabaseHeader) error {
+	header.Version = DatabaseVersion
+	payload, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.writeRecord(diskRecordHeader, payload); err != nil {
+		return err
+	}
+	s.header = &header
+	return nil
+}
+
+// AddFile adds a file to storage and appends it to the log.
+func (s *DiskStorage) AddFile(file *File) error {
+	parentFolder, err := s.MemoryStorage.GetFolder(filepath.Dir(file.Path))
+	if err != nil {
+		return err
+	}
+	parentFolder.load()
+	s.loadHash(file.Hash)
+
+	s.mu.Lock()
+	if files, ok := s.entries[parentFolder.Path]; ok {
+		if _, ok := files[file.Name]; ok {
+			s.mu.Unlock()
+			return fmt.Errorf("file %s already exists", file.Path)
+		}
+	}
+	offset := s.end
+	err = s.writeRecord(diskRecordAdd, encodeDiskFile(file))
+	if err == nil {
+		s.index(parentFolder.Path, file.Name, diskEntry{offset: offset, hash: file.Hash, size: file.Size})
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := s.MemoryStorage.addFile(file); err != nil {
+		return err
+	}
+	s.publish(ChangeEvent{Type: FileAdded, File: file, Folder: parentFolder})
+	return nil
+}
+
+// AddFolder records a folder with its metadata and appends it to the log.
+func (s *DiskStorage) AddFolder(path string, info FolderInfo) error {
+	folder, err := s.MemoryStorage.GetFolder(path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	err = s.writeRecord(diskRecordFolder, encodeDiskFolder(path, info))
+	if err == nil {
+		s.folderInfos[path] = info
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	folder.Info = &info
+	s.publish(ChangeEvent{Type: FolderAdded, Folder: folder})
+	return nil
+}
+
+// AddFiles adds a batch of files to storage and appends them to the log. It may be called concurrently.
+func (s *DiskStorage) AddFiles(files []*File) error {
+	errs := []error{}
+	for _, file := range files {
+		if err := s.AddFile(file); err != nil {
+			errs = append(errs, fmt.Errorf("failed to add file %s: %w", file.Path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RemoveFile removes a file from storage and records the removal in the log.
+func (s *DiskStorage) RemoveFile(file *File) error {
+	s.mu.Lock()
+	err := s.writeRecord(diskRecordRemove, []byte(file.Path))
+	if err == nil {
+		s.unindex(filepath.Dir(file.Path), file.Name)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	parentFolder, err := s.MemoryStorage.removeFile(file)
+	if err != nil {
+		return err
+	}
+	s.publish(ChangeEvent{Type: FileRemoved, File: file, Folder: parentFolder})
+	return nil
+}
+
+// RemoveFolder removes a folder with all its files and subfolders and records the removal in the log.
+func (s *DiskStorage) RemoveFolder(path string) error {
+	folder, err := s.MemoryStorage.removeFolder(path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	err = s.writeRecord(diskRecordRemoveFolder, []byte(path))
+	if err == nil {
+		s.unindexFolder(path)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.publish(ChangeEvent{Type: FolderRemoved, Folder: folder, Path: path})
+	return nil
+}
+
+// MoveFolder moves a folder into the folder dstParent and records the move in the log.
+func (s *DiskStorage) MoveFolder(src string, dstParent string) error {
+	folder, err := s.MemoryStorage.moveFolder(src, dstParent)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	err = s.writeRecord(diskRecordMoveFolder, []byte(src+"\x00"+dstParent))
+	if err == nil {
+		s.reindexFolder(src, filepath.Join(dstParent, filepath.Base(src)))
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.publish(ChangeEvent{Type: FolderMoved, Folder: folder, Path: src})
+	return nil
+}
+
+// GetMatchedFiles loads every folder holding a duplicated hash and returns the matched file groups.
+func (s *DiskStorage) GetMatchedFiles() ([]*MatchedFileGroup, error) {
+	s.mu.Lock()
+	seen := map[string]bool{}
+	folders := []string{}
+	for hash, paths := range s.hashFolders {
+		if s.hashes[hash] < 2 {
+			continue
+		}
+		for path := range paths {
+			if !seen[path] {
+				seen[path] = true
+				folders = append(folders, path)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, path := range folders {
+		folder, err := s.MemoryStorage.GetFolder(path)
+		if err != nil {
+			return nil, err
+		}
+		folder.load()
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.MemoryStorage.GetMatchedFiles()
+}
+
+// FindByHash loads the folders holding the hash and returns its non-empty files.
+func (s *DiskStorage) FindByHash(hash string) ([]*File, error) {
+	s.loadHash(hash)
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return s.MemoryStorage.FindByHash(hash)
+}
+
+// Err returns the first error met while loading a folder from the log. The files
+// which could not be read are left out of their folder and of its file count.
+func (s *DiskStorage) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadErr
+}
+
+// FindBySizeRange returns all files with minSize <= size <= maxSize,
+// loading only the folders holding such files. A negative maxSize means no upper bound.
+func (s *DiskStorage) FindBySizeRange(minSize int64, maxSize int64) ([]*File, error) {
+	s.mu.Lock()
+	folders := []string{}
+	for path, files := range s.entries {
+		for _, entry := range files {
+			if entry.size >= minSize && (maxSize < 0 || entry.size <= maxSize) {
+				folders = append(folders, path)
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	result := []*File{}
+	for _, path := range folders {
+		folder, err := s.MemoryStorage.GetFolder(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range folder.GetFiles() {
+			if file.Size >= minSize && (maxSize < 0 || file.Size <= maxSize) {
+				result = append(result, file)
+			}
+		}
+	}
+	return result, nil
+}
+
+// Sync flushes pending records to disk.
+func (s *DiskStorage) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Close flushes pending records and closes the database file.
+func (s *DiskStorage) Close() error {
+	if err := s.Sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// loadFolder reads the file records of a folder from the log.
+func (s *DiskStorage) loadFolder(folder *Folder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.writer.Flush(); err != nil {
+		s.setLoadErr(fmt.Errorf("failed to load folder %s: %w", folder.Path, err))
+		return
+	}
+
+	for name, entry := range s.entries[folder.Path] {
+		if _, ok := folder.files.Load(name); ok {
+			continue
+		}
+		file, err := s.readFile(entry.offset)
+		if err != nil {
+			// the folder only counts the files it holds
+			s.setLoadErr(fmt.Errorf("failed to load file %s: %w", filepath.Join(folder.Path, name), err))
+			atomic.AddInt32(&folder.fileCount, -1)
+			atomic.AddInt64(&folder.fileSize, -entry.size)
+			folder.invalidateCache()
+			continue
+		}
+		// the record keeps the original path if the folder was moved
+		file.Name = name
+		file.Path = filepath.Join(folder.Path, name)
+		folder.files.Store(file.Name, file)
+		file.Parent = folder
+		s.MemoryStorage.addHash(file)
+	}
+}
+
+// setLoadErr records the first error met while loading a folder.
+func (s *DiskStorage) setLoadErr(err error) {
+	if s.loadErr == nil {
+		s.loadErr = err
+	}
+}
+
+// loadHash loads the folders holding files with the given hash,
+// so a newly added file is matched against them.
+func (s *DiskStorage) loadHash(hash string) {
+	s.mu.Lock()
+	folders := []string{}
+	for path := range s.hashFolders[hash] {
+		folders = append(folders, path)
+	}
+	s.mu.Unlock()
+
+	for _, path := range folders {
+		if folder, err := s.MemoryStorage.GetFolder(path); err == nil {
+			folder.load()
+		}
+	}
+}
+
+// index adds a file record to the in-memory index.
+func (s *DiskStorage) index(folder string, name string, entry diskEntry) {
+	files, ok := s.entries[folder]
+	if !ok {
+		files = make(map[string]diskEntry)
+		s.entries[folder] = files
+	}
+	if old, ok := files[name]; ok && old.size > 0 {
+		s.unindexHash(folder, old.hash)
+	}
+	files[name] = entry
+	if entry.size > 0 {
+		s.indexHash(folder, entry.hash)
+	}
+}
+
+// indexHash counts a non-empty file of the hash in the folder.
+func (s *DiskStorage) indexHash(folder string, hash string) {
+	s.hashes[hash]++
+	folders, ok := s.hashFolders[hash]
+	if !ok {
+		folders = make(map[string]int)
+		s.hashFolders[hash] = folders
+	}
+	folders[folder]++
+}
+
+// unindexHash uncounts a non-empty file of the hash in the folder.
+func (s *DiskStorage) unindexHash(folder string, hash string) {
+	s.hashes[hash]--
+	if s.hashes[hash] <= 0 {
+		delete(s.hashes, hash)
+	}
+	folders := s.hashFolders[hash]
+	folders[folder]--
+	if folders[folder] <= 0 {
+		delete(folders, folder)
+	}
+	if len(folders) == 0 {
+		delete(s.hashFolders, hash)
+	}
+}
+
+// unindex removes a file record from the in-memory index.
+func (s *DiskStorage) unindex(folder string, name string) {
+	files, ok := s.entries[folder]
+	if !ok {
+		return
+	}
+	if entry, ok := files[name]; ok {
+		if entry.size > 0 {
+			s.unindexHash(folder, entry.hash)
+		}
+		delete(files, name)
+	}
+	if len(files) == 0 {
+		delete(s.entries, folder)
+	}
+}
+
+// unindexFolder removes all file and folder records below a folder from the in-memory index.
+func (s *DiskStorage) unindexFolder(path string) {
+	for folder := range s.folderInfos {
+		if isSubPath(folder, path) {
+			delete(s.folderInfos, folder)
+		}
+	}
+	for folder, files := range s.entries {
+		if !isSubPath(folder, path) {
+			continue
+		}
+		for name := range files {
+			s.unindex(folder, name)
+		}
+	}
+}
+
+// reindexFolder moves all file and folder records below a folder to a new path in the in-memory index.
+func (s *DiskStorage) reindexFolder(src string, dst string) {
+	movedInfos := make(map[string]FolderInfo)
+	for folder, info := range s.folderInfos {
+		if isSubPath(folder, src) {
+			movedInfos[dst+folder[len(src):]] = info
+			delete(s.folderInfos, folder)
+		}
+	}
+	for folder, info := range movedInfos {
+		s.folderInfos[folder] = info
+	}
+
+	moved := make(map[string]map[string]diskEntry)
+	for folder, files := range s.entries {
+		if isSubPath(folder, src) {
+			moved[dst+folder[len(src):]] = files
+			delete(s.entries, folder)
+			for _, entry := range files {
+				if entry.size > 0 {
+					s.unindexHash(folder, entry.hash)
+				}
+			}
+		}
+	}
+	for folder, files := range moved {
+		s.entries[folder] = files
+		for _, entry := range files {
+			if entry.size > 0 {
+				s.indexHash(folder, entry.hash)
+			}
+		}
+	}
+}
+
+// readIndex scans the log and builds the in-memory index.
+func (s *DiskStorage) readIndex() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		if _, err := s.file.Write([]byte(diskMagic)); err != nil {
+			return err
+		}
+		s.end = int64(len(diskMagic))
+		return nil
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(s.file, 0, info.Size()))
+	magic := make([]byte, len(diskMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != diskMagic {
+		return fmt.Errorf("not a dedup database")
+	}
+
+	offset := int64(len(diskMagic))
+	for {
+		recordType, payload, n, err := readDiskRecord(reader, info.Size()-offset)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if errors.Is(err, io.ErrUnexpectedEOF) {
+			// ignore a record truncated by an interrupted write
+			break
+		} else if err != nil {
+			return fmt.Errorf("record at offset %d: %w", offset, err)
+		}
+
+		switch recordType {
+		case diskRecordAdd:
+			file, err := decodeDiskFile(payload)
+			if err != nil {
+				return fmt.Errorf("corrupted record at offset %d: %w", offset, err)
+			}
+			s.index(filepath.Dir(file.Path), file.Name, diskEntry{offset: offset, hash: file.Hash, size: file.Size})
+		case diskRecordRemove:
+			path := string(payload)
+			s.unindex(filepath.Dir(path), filepath.Base(path))
+		case diskRecordRemoveFolder:
+			s.unindexFolder(string(payload))
+		case diskRecordMoveFolder:
+			src, dstParent, _ := strings.Cut(string(payload), "\x00")
+			s.reindexFolder(src, filepath.Join(dstParent, filepath.Base(src)))
+		case diskRecordFolder:
+			path, info, err := decodeDiskFolder(payload)
+			if err != nil {
+				return fmt.Errorf("corrupted record at offset %d: %w", offset, err)
+			}
+			s.folderInfos[path] = info
+		case diskRecordHeader:
+			header := &DatabaseHeader{}
+			if err := json.Unmarshal(payload, header); err != nil {
+				return fmt.Errorf("corrupted header at offset %d: %w", offset, err)
+			}
+			s.header = header
+		default:
+			return fmt.Errorf("unknown record type %d at offset %d", recordType, offset)
+		}
+		offset += n
+	}
+	s.end = offset
+	return nil
+}
+
+// readFile reads the file record at the given offset.
+func (s *DiskStorage) readFile(offset int64) (*File, error) {
+	reader := bufio.NewReader(io.NewSectionReader(s.file, offset, s.end-offset))
+	recordType, payload, _, err := readDiskRecord(reader, s.end-offset)
+	if err != nil {
+		return nil, err
+	}
+	if recordType != diskRecordAdd {
+		return nil, fmt.Errorf("unexpected record type %d at offset %d", recordType, offset)
+	}
+	return decodeDiskFile(payload)
+}
+
+// writeRecord appends a record to the log.
+func (s *DiskStorage) writeRecord(recordType byte, payload []byte) error {
+	if len(payload) > diskMaxRecord {
+		return fmt.Errorf("record of %d bytes exceeds the limit of %d bytes", len(payload), diskMaxRecord)
+	}
+	header := make([]byte, 1+binary.MaxVarintLen64)
+	header[0] = recordType
+	n := 1 + binary.PutUvarint(header[1:], uint64(len(payload)))
+
+	if _, err := s.writer.Write(header[:n]); err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(payload); err != nil {
+		return err
+	}
+	s.end += int64(n + len(payload))
+	return nil
+}
+
+// readDiskRecord reads a record from the available bytes left in the log and returns
+// its type, payload and encoded length. A record cut by the end of the log, like the
+// last one of an interrupted write, returns io.ErrUnexpectedEOF.
+func readDiskRecord(reader *bufio.Reader, available int64) (byte, []byte, int64, error) {
+	recordType, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return 0, nil, 0, io.ErrUnexpectedEOF
+	}
+	if length > diskMaxRecord {
+		return 0, nil, 0, fmt.Errorf("%w: record of %d bytes", errDiskCorrupted, length)
+	}
+	if int64(length) > available-1-int64(len(binary.AppendUvarint(nil, length))) {
+		return 0, nil, 0, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return 0, nil, 0, io.ErrUnexpectedEOF
+	}
+
+	n := 1 + len(binary.AppendUvarint(nil, length)) + int(length)
+	return recordType, payload, int64(n), nil
+}
+
+// encodeDiskFile encodes a file into a record payload.
+func encodeDiskFile(file *File) []byte {
+	buf := []byte{}
+	buf = binary.AppendUvarint(buf, uint64(len(file.Path)))
+	buf = append(buf, file.Path...)
+	buf = binary.AppendUvarint(buf, uint64(len(file.Hash)))
+	buf = append(buf, file.Hash...)
+	buf = binary.AppendVarint(buf, file.Size)
+	buf = binary.AppendVarint(buf, file.ModTime.Unix())
+	buf = binary.AppendUvarint(buf, uint64(file.ModTime.Nanosecond()))
+
+	tags := []byte{}
+	if len(file.Tags) > 0 {
+		tags, _ = json.Marshal(file.Tags)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(tags)))
+	buf = append(buf, tags...)
+	buf = binary.AppendUvarint(buf, file.Device)
+	buf = binary.AppendUvarint(buf, file.Inode)
+	return buf
+}
+
+// decodeDiskFile decodes a record payload into a file.
+func decodeDiskFile(payload []byte) (*File, error) {
+	d := diskDecoder{buf: payload}
+	file := &File{}
+	file.Path = string(d.bytes())
+	file.Hash = string(d.bytes())
+	file.Size = d.varint()
+	sec := d.varint()
+	nsec := int64(d.uvarint())
+	file.ModTime = time.Unix(sec, nsec)
+	if tags := d.bytes(); len(tags) > 0 && d.err == nil {
+		if err := json.Unmarshal(tags, &file.Tags); err != nil {
+			return nil, err
+		}
+	}
+	// records written before hard links were tracked end here
+	if len(d.buf) > 0 {
+		file.Device = d.uvarint()
+		file.Inode = d.uvarint()
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	file.Name = filepath.Base(file.Path)
+	return file, nil
+}
+
+// encodeDiskFolder encodes a folder with its metadata into a record payload.
+func encodeDiskFolder(path string, info FolderInfo) []byte {
+	buf := []byte{}
+	buf = binary.AppendUvarint(buf, uint64(len(path)))
+	buf = append(buf, path...)
+	buf = binary.AppendVarint(buf, info.ModTime.Unix())
+	buf = binary.AppendUvarint(buf, uint64(info.ModTime.Nanosecond()))
+	buf = binary.AppendUvarint(buf, uint64(info.Mode))
+	buf = binary.AppendUvarint(buf, uint64(info.UID))
+	buf = binary.AppendUvarint(buf, uint64(info.GID))
+	return buf
+}
+
+// decodeDiskFolder decodes a record payload into a folder path and its metadata.
+func decodeDiskFolder(payload []byte) (string, FolderInfo, error) {
+	d := diskDecoder{buf: payload}
+	path := string(d.bytes())
+	sec := d.varint()
+	nsec := int64(d.uvarint())
+	info := FolderInfo{
+		ModTime: time.Unix(sec, nsec),
+		Mode:    fs.FileMode(d.uvarint()),
+		UID:     uint32(d.uvarint()),
+		GID:     uint32(d.uvarint()),
+	}
+	if d.err != nil {
+		return "", FolderInfo{}, d.err
+	}
+	return path, info, nil
+}
+
+// diskDecoder reads varint encoded fields from a record payload.
+type diskDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *diskDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = fmt.Errorf("invalid record")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *diskDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = fmt.Errorf("invalid record")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *diskDecoder) bytes() []byte {
+	length := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.buf)) < length {
+		d.err = fmt.Errorf("invalid record")
+		return nil
+	}
+	v := d.buf[:length]
+	d.buf = d.buf[length:]
+	return v
+}
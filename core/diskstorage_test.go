@@ -0,0 +1,203 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// diskFiles returns the files of the storage as path, hash, size and modification time, sorted by path.
+func diskFiles(storage Storage) string {
+	lines := []string{}
+	for file := range storage.Walk(".") {
+		lines = append(lines, fmt.Sprintf("%s %s %d %s", file.Path, file.Hash, file.Size, file.ModTime.UTC().Format(time.RFC3339Nano)))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// openDisk opens the database at path and closes it at the end of the test.
+func openDisk(t *testing.T, path string) *DiskStorage {
+	t.Helper()
+	storage, err := OpenDiskStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+// addDiskFiles adds a file for every path to the storage, named hash:path.
+func addDiskFiles(t *testing.T, storage Storage, specs ...string) {
+	t.Helper()
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 123, time.UTC)
+	for _, spec := range specs {
+		hash, path, _ := strings.Cut(spec, ":")
+		file := &File{Name: filepath.Base(path), Path: path, Size: int64(len(hash)) * 10, Hash: hash, ModTime: modTime}
+		if err := storage.AddFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDiskStorageReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.db")
+	storage := openDisk(t, path)
+	addDiskFiles(t, storage, "AAAA:music/a/1", "AAAA:music/b/1", "BBBBBB:music/b/2", "CC:docs/x")
+	tagged := &File{Name: "t.mp3", Path: "music/t.mp3", Size: 5, Hash: "DDDD", Tags: map[string]string{"title": "Song"}, Device: 3, Inode: 7}
+	if err := storage.AddFile(tagged); err != nil {
+		t.Fatal(err)
+	}
+	info := FolderInfo{ModTime: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC), Mode: 0o755 | os.ModeDir, UID: 1000, GID: 100}
+	if err := storage.AddFolder("empty", info); err != nil {
+		t.Fatal(err)
+	}
+	header := DatabaseHeader{Roots: []string{"/data"}, Hasher: HasherSHA256}
+	if err := storage.SetHeader(header); err != nil {
+		t.Fatal(err)
+	}
+	want := diskFiles(storage)
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openDisk(t, path)
+	if got := reopened.FileCount(); got != 5 {
+		t.Errorf("reopened %d files, want 5", got)
+	}
+	if got := diskFiles(reopened); got != want {
+		t.Errorf("reopened files:\n%s\nwant:\n%s", got, want)
+	}
+	if got := reopened.Header(); got == nil || got.Hasher != HasherSHA256 || fmt.Sprint(got.Roots) != "[/data]" || got.Version != DatabaseVersion {
+		t.Errorf("reopened header %+v, want %+v", got, header)
+	}
+	folder, err := reopened.GetFolder("empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if folder.Info == nil || !folder.Info.ModTime.Equal(info.ModTime) || folder.Info.Mode != info.Mode || folder.Info.UID != 1000 || folder.Info.GID != 100 {
+		t.Errorf("reopened folder info %+v, want %+v", folder.Info, info)
+	}
+	files, err := reopened.FindByHash("DDDD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Tags["title"] != "Song" || files[0].Device != 3 || files[0].Inode != 7 {
+		t.Errorf("reopened tagged file %+v", files)
+	}
+	groups, err := reopened.GetMatchedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Hash != "AAAA" || len(groups[0].Files) != 2 {
+		t.Errorf("reopened %d matched groups, want the 2 files of AAAA", len(groups))
+	}
+}
+
+func TestDiskStorageReplayChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.db")
+	storage := openDisk(t, path)
+	addDiskFiles(t, storage,
+		"AAAA:music/a/1", "BBBB:music/a/2", "AAAA:music/b/1",
+		"CCCC:music/c/1", "CCCC:music/c/sub/2", "DDDD:old/d/1")
+
+	removed, err := storage.FindByHash("BBBB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.RemoveFile(removed[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.RemoveFolder("music/c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.MoveFolder("old/d", "music"); err != nil {
+		t.Fatal(err)
+	}
+	// a file added after the move is replayed at its own path
+	addDiskFiles(t, storage, "DDDD:music/d/2", "BBBB:music/b/2")
+	want := diskFiles(storage)
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openDisk(t, path)
+	if got := diskFiles(reopened); got != want {
+		t.Errorf("replayed files:\n%s\nwant:\n%s", got, want)
+	}
+	if !strings.Contains(want, "music/d/1 DDDD") || strings.Contains(want, "music/c") || strings.Contains(want, "music/a/2") {
+		t.Errorf("unexpected files after the changes:\n%s", want)
+	}
+	for hash, count := range map[string]int{"AAAA": 2, "BBBB": 1, "CCCC": 0, "DDDD": 2} {
+		files, err := reopened.FindByHash(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != count {
+			t.Errorf("replayed %d files with hash %s, want %d", len(files), hash, count)
+		}
+	}
+	if _, ok := reopened.MemoryStorage.folders.Load("old/d"); ok {
+		t.Errorf("moved folder old/d is still stored")
+	}
+}
+
+func TestDiskStorageTruncatedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.db")
+	storage := openDisk(t, path)
+	addDiskFiles(t, storage, "AAAA:music/a/1", "AAAA:music/b/1", "BBBB:music/b/2")
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cut the last record as an interrupted write would
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openDisk(t, path)
+	if got, want := diskFiles(reopened), "music/a/1 AAAA 40 2024-05-01T12:00:00.000000123Z\nmusic/b/1 AAAA 40 2024-05-01T12:00:00.000000123Z"; got != want {
+		t.Errorf("files after a truncated record:\n%s\nwant:\n%s", got, want)
+	}
+	// the log is appended after the last complete record
+	addDiskFiles(t, reopened, "CCCC:music/c/1")
+	want := diskFiles(reopened)
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := diskFiles(openDisk(t, path)); got != want {
+		t.Errorf("files after appending to a truncated log:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDiskStorageCorruptedLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.db")
+	storage := openDisk(t, path)
+	addDiskFiles(t, storage, "AAAA:music/a/1")
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := binary.AppendUvarint([]byte{diskRecordAdd}, 1<<60)
+	if _, err := file.Write(append(record, "payload"...)); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if _, err := OpenDiskStorage(path); !errors.Is(err, errDiskCorrupted) {
+		t.Errorf("opened a database with a corrupted record length: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ChangeType represents the type of a storage change.
+type ChangeType int
+
+const (
+	FileAdded ChangeType = iota
+	FileRemoved
+	FolderRemoved
+	FolderMoved
+	FolderAdded
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case FileAdded:
+		return "file added"
+	case FileRemoved:
+		return "file removed"
+	case FolderRemoved:
+		return "folder removed"
+	case FolderMoved:
+		return "folder moved"
+	case FolderAdded:
+		return "folder added"
+	}
+	return fmt.Sprintf("change %d", int(t))
+}
+
+// ChangeEvent describes a change of storage, published after the change is complete.
+type ChangeEvent struct {
+	Type ChangeType
+	// File is the added or removed file.
+	File *File
+	// Folder is the parent of the added or removed file, the removed folder
+	// with its contents, the moved folder at its new path or the added folder.
+	Folder *Folder
+	// Path is the path of the removed folder or the old path of the moved folder.
+	Path string
+}
+
+// changeSubscribers holds the subscribers of the storage change events.
+type changeSubscribers struct {
+	mu     sync.RWMutex
+	nextID int
+	ids    []int
+	fns    []func(event ChangeEvent)
+}
+
+// Subscribe registers fn to be called for every change and returns a function
+// removing it. fn is called synchronously by the goroutine making the change
+// and must not modify the storage; with concurrent writers it is called concurrently.
+func (s *MemoryStorage) Subscribe(fn func(event ChangeEvent)) func() {
+	subscribers := &s.subscribers
+	subscribers.mu.Lock()
+	defer subscribers.mu.Unlock()
+
+	id := subscribers.nextID
+	subscribers.nextID++
+	subscribers.ids = append(subscribers.ids, id)
+	subscribers.fns = append(subscribers.fns, fn)
+
+	return func() {
+		subscribers.mu.Lock()
+		defer subscribers.mu.Unlock()
+		for i, subscriberID := range subscribers.ids {
+			if subscriberID == id {
+				subscribers.ids = append(subscribers.ids[:i:i], subscribers.ids[i+1:]...)
+				subscribers.fns = append(subscribers.fns[:i:i], subscribers.fns[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// hasSubscribers reports whether any change subscriber is registered.
+func (s *MemoryStorage) hasSubscribers() bool {
+	s.subscribers.mu.RLock()
+	defer s.subscribers.mu.RUnlock()
+	return len(s.subscribers.fns) > 0
+}
+
+// publish calls every subscriber with the event.
+func (s *MemoryStorage) publish(event ChangeEvent) {
+	s.subscribers.mu.RLock()
+	fns := s.subscribers.fns
+	s.subscribers.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(event)
+	}
+}
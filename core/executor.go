@@ -15,6 +15,8 @@ type Executor struct {
 	logger       Logger
 	done         bool
 	progressChan chan ProgressUpdate
+	// mounts resolves the tasks of a merged storage, rootPath is unused when set.
+	mounts []Mount
 }
 
 // ProgressUpdate represents a progress update during task execution.
@@ -35,6 +37,11 @@ func NewExecutor(storage Storage, rootPath string, tasks []FileActionTask, logge
 	}
 }
 
+// SetMounts makes the executor run every task in the root of its mounted database.
+func (e *Executor) SetMounts(mounts []Mount) {
+	e.mounts = mounts
+}
+
 // ProgressChannel returns the progress update channel.
 func (e *Executor) ProgressChannel() <-chan ProgressUpdate {
 	return e.progressChan
@@ -42,11 +49,21 @@ func (e *Executor) ProgressChannel() <-chan ProgressUpdate {
 
 // Execute runs all tasks with progress reporting and cancellation support.
 func (e *Executor) Execute(ctx context.Context) error {
-	root, err := os.OpenRoot(e.rootPath)
-	if err != nil {
-		return err
+	var root *os.Root
+	if len(e.mounts) == 0 {
+		var err error
+		root, err = os.OpenRoot(e.rootPath)
+		if err != nil {
+			return err
+		}
+		defer root.Close()
 	}
-	defer root.Close()
+	mountRoots := map[string]*os.Root{}
+	defer func() {
+		for _, mountRoot := range mountRoots {
+			mountRoot.Close()
+		}
+	}()
 
 	totalTasks := len(e.tasks)
 	for i, task := range e.tasks {
@@ -55,7 +72,7 @@ func (e *Executor) Execute(ctx context.Context) error {
 			return ctx.Err()
 		default:
 			// TODO: execute task
-			err := ExecuteFileActionTask(e.storage, root, &task)
+			err := e.executeTask(root, mountRoots, &task)
 			message := task.String()
 
 			if err != nil && errors.Is(err, ErrNotEmptyFolder) {
@@ -93,3 +110,24 @@ func (e *Executor) Execute(ctx context.Context) error {
 
 	return nil
 }
+
+// executeTask runs a task in root, or in the root of its mounted database.
+func (e *Executor) executeTask(root *os.Root, mountRoots map[string]*os.Root, task *FileActionTask) error {
+	if len(e.mounts) == 0 {
+		return ExecuteFileActionTask(e.storage, root, task)
+	}
+
+	mount, err := taskMount(e.mounts, task)
+	if err != nil {
+		return err
+	}
+	mountRoot, ok := mountRoots[mount.Label]
+	if !ok {
+		mountRoot, err = os.OpenRoot(mount.Root)
+		if err != nil {
+			return err
+		}
+		mountRoots[mount.Label] = mountRoot
+	}
+	return executeFileActionTask(e.storage, mountRoot, task, mount.Label)
+}
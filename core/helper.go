@@ -3,10 +3,17 @@
 package core
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/kalafut/imohash"
 )
@@ -32,6 +39,30 @@ func getFileHash(file fs.File, hash imohash.ImoHash) (string, error) {
 
 }
 
+// getContentHash computes a full hash of the file content with a standard hash algorithm.
+func getContentHash(file fs.File, hash hash.Hash) (string, error) {
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+	return base64.RawStdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashFile hashes the file with the given hasher mode and returns the audio tags, if any.
+func hashFile(file fs.File, hasher string, hash imohash.ImoHash) (string, map[string]string, error) {
+	switch hasher {
+	case HasherAudio:
+		return getAudioHash(file, hash)
+	case HasherSHA256:
+		value, err := getContentHash(file, sha256.New())
+		return value, nil, err
+	case HasherMD5:
+		value, err := getContentHash(file, md5.New())
+		return value, nil, err
+	}
+	value, err := getFileHash(file, hash)
+	return value, nil, err
+}
+
 // FormatFileSize formats a file size in bytes into a human-readable string.
 func FormatFileSize(size int64) string {
 	units := []string{"B", "KB", "MB", "GB", "TB", "PB"}
@@ -43,3 +74,33 @@ func FormatFileSize(size int64) string {
 	}
 	return fmt.Sprintf("%.2f%s", s, units[i])
 }
+
+// ParseFileSize parses a size in bytes, optionally followed by a unit as written
+// by FormatFileSize, like 512, 1.5MB or 10G.
+func ParseFileSize(value string) (int64, error) {
+	units := []string{"B", "KB", "MB", "GB", "TB", "PB"}
+	number := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := 1.0
+	for i := len(units) - 1; i >= 0; i-- {
+		unit := units[i]
+		if i > 0 && !strings.HasSuffix(number, unit) {
+			unit = strings.TrimSuffix(unit, "B")
+		}
+		if strings.HasSuffix(number, unit) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit))
+			multiplier = math.Pow(1024, float64(i))
+			break
+		}
+	}
+
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(size * multiplier), nil
+}
+
+// isSubPath reports whether path is equal to or below the folder parent.
+func isSubPath(path string, parent string) bool {
+	return path == parent || parent == "." || strings.HasPrefix(path, parent+string(filepath.Separator))
+}
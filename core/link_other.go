@@ -0,0 +1,15 @@
+//go:build !unix
+
+package core
+
+import "io/fs"
+
+// fileLink returns zeros, hard links are not detected on this platform.
+func fileLink(info fs.FileInfo) (uint64, uint64) {
+	return 0, 0
+}
+
+// fileOwner returns zeros, owners are not recorded on this platform.
+func fileOwner(info fs.FileInfo) (uint32, uint32) {
+	return 0, 0
+}
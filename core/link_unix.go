@@ -0,0 +1,26 @@
+//go:build unix
+
+package core
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileLink returns the device and inode of a file with more than one hard link, zeros otherwise.
+func fileLink(info fs.FileInfo) (uint64, uint64) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink <= 1 {
+		return 0, 0
+	}
+	return uint64(stat.Dev), uint64(stat.Ino)
+}
+
+// fileOwner returns the user and group id owning a file.
+func fileOwner(info fs.FileInfo) (uint32, uint32) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return stat.Uid, stat.Gid
+}
@@ -0,0 +1,206 @@
+package core
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNoFullHash = errors.New("database has no full content hashes")
+
+// Manifest holds the hashes of a sha256sum or md5sum checksum file.
+type Manifest struct {
+	// Hasher is HasherSHA256 or HasherMD5, depending on the hash length.
+	Hasher string
+	// ModTime is when the manifest was written; files modified later are hashed again.
+	ModTime time.Time
+	// Hashes maps the paths relative to the root to the base64 encoded hashes used by storage.
+	Hashes map[string]string
+	// Sizes maps the paths to the sizes recorded by WriteManifest; files of another size,
+	// or without a recorded size, are hashed again.
+	Sizes map[string]int64
+}
+
+// manifestSizePrefix starts the comment line recording the size of the file listed on the
+// next line, which sha256sum -c and md5sum -c skip.
+const manifestSizePrefix = "# size "
+
+// ReadManifest parses the lines of a checksum file in the "<hex>  <path>" format
+// written by sha256sum and md5sum, including binary mode, escaped names and
+// the BSD style "SHA256 (<path>) = <hex>" lines. All hashes must be of the same kind.
+// A "# size <bytes>" comment records the size of the file listed on the next line.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	manifest := &Manifest{Hashes: map[string]string{}, Sizes: map[string]int64{}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	size := int64(-1)
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if recorded, ok := strings.CutPrefix(line, manifestSizePrefix); ok {
+			parsed, err := strconv.ParseInt(recorded, 10, 64)
+			if err != nil || parsed < 0 {
+				return nil, fmt.Errorf("invalid size on manifest line %d: %q", lineNumber, line)
+			}
+			size = parsed
+			continue
+		}
+		if strings.TrimSpace(line) == "" || line[0] == '#' {
+			continue
+		}
+
+		escaped := line[0] == '\\'
+		if escaped {
+			line = line[1:]
+		}
+		hexHash, path, hasher, ok := parseManifestLine(line)
+		if !ok {
+			return nil, fmt.Errorf("invalid manifest line %d: %q", lineNumber, line)
+		}
+		if escaped {
+			path = unescapeManifestPath(path)
+		}
+
+		if hasher == "" {
+			return nil, fmt.Errorf("unsupported hash on manifest line %d", lineNumber)
+		}
+		if manifest.Hasher == "" {
+			manifest.Hasher = hasher
+		} else if manifest.Hasher != hasher {
+			return nil, fmt.Errorf("manifest line %d mixes %s with %s hashes", lineNumber, hasher, manifest.Hasher)
+		}
+
+		raw, err := hex.DecodeString(hexHash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hash on manifest line %d: %w", lineNumber, err)
+		}
+		path = filepath.Clean(filepath.FromSlash(path))
+		manifest.Hashes[path] = base64.RawStdEncoding.EncodeToString(raw)
+		if size >= 0 {
+			manifest.Sizes[path] = size
+			size = -1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// LoadManifest reads a checksum file, see ReadManifest.
+func LoadManifest(path string) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	manifest, err := ReadManifest(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", path, err)
+	}
+	if info, err := file.Stat(); err == nil {
+		manifest.ModTime = info.ModTime()
+	}
+	return manifest, nil
+}
+
+// Hash returns the hash listed for the file at path relative to the root, when the
+// manifest recorded its size and the file was not modified after the manifest was written.
+func (m *Manifest) Hash(path string, size int64, modTime time.Time) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	hash, ok := m.Hashes[path]
+	recorded, sized := m.Sizes[path]
+	if !ok || !sized || recorded != size || (!m.ModTime.IsZero() && modTime.After(m.ModTime)) {
+		return "", false
+	}
+	return hash, true
+}
+
+// WriteManifest writes every file of storage as a checksum file which can be
+// checked with sha256sum -c or md5sum -c, depending on hasher, in the root.
+func WriteManifest(w io.Writer, storage Storage, hasher string) error {
+	if hasher != HasherSHA256 && hasher != HasherMD5 {
+		return fmt.Errorf("%w: hasher %s (scan with -hash %s)", ErrNoFullHash, hasher, HasherSHA256)
+	}
+
+	files := []*File{}
+	for file := range storage.Walk(".") {
+		files = append(files, file)
+	}
+	sortFilesByPath(files)
+
+	writer := bufio.NewWriter(w)
+	for _, file := range files {
+		raw, err := base64.RawStdEncoding.DecodeString(file.Hash)
+		if err != nil || hex.EncodedLen(len(raw)) != manifestHashLength(hasher) {
+			return fmt.Errorf("%w: file %s", ErrNoFullHash, file.Path)
+		}
+
+		path := filepath.ToSlash(file.Path)
+		prefix := ""
+		if strings.ContainsAny(path, "\\\n\r") {
+			prefix = "\\"
+			path = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(path)
+		}
+		if _, err := fmt.Fprintf(writer, "%s%d\n%s%s  %s\n", manifestSizePrefix, file.Size, prefix, hex.EncodeToString(raw), path); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// parseManifestLine splits a manifest line into the hex hash, the path and the
+// hasher, which is empty for an unsupported hash.
+func parseManifestLine(line string) (string, string, string, bool) {
+	if tag, rest, ok := strings.Cut(line, " ("); ok && !strings.Contains(tag, " ") {
+		index := strings.LastIndex(rest, ") = ")
+		if index < 0 {
+			return "", "", "", false
+		}
+		hasher := ""
+		switch tag {
+		case "SHA256":
+			hasher = HasherSHA256
+		case "MD5":
+			hasher = HasherMD5
+		}
+		return rest[index+4:], rest[:index], hasher, true
+	}
+
+	hexHash, rest, ok := strings.Cut(line, " ")
+	if !ok || len(rest) < 2 || (rest[0] != ' ' && rest[0] != '*') {
+		return "", "", "", false
+	}
+	hasher := ""
+	for _, candidate := range []string{HasherSHA256, HasherMD5} {
+		if len(hexHash) == manifestHashLength(candidate) {
+			hasher = candidate
+		}
+	}
+	return hexHash, rest[1:], hasher, true
+}
+
+// unescapeManifestPath reverses the escaping of names with backslashes and newlines.
+func unescapeManifestPath(path string) string {
+	return strings.NewReplacer("\\\\", "\\", "\\n", "\n", "\\r", "\r").Replace(path)
+}
+
+// manifestHashLength returns the number of hex digits of a hash of the hasher.
+func manifestHashLength(hasher string) int {
+	if hasher == HasherMD5 {
+		return 32
+	}
+	return 64
+}
@@ -0,0 +1,228 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sha256Hash returns the hash of the content as stored by the sha256 hasher.
+func sha256Hash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// sha256Hex returns the hash of the content as listed by sha256sum.
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestReadManifest(t *testing.T) {
+	input := strings.Join([]string{
+		"# written by hand",
+		"# size 5",
+		sha256Hex("a") + "  music/a.flac",
+		sha256Hex("b") + " *music/b.flac\r",
+		"",
+		"# size 7",
+		"\\" + sha256Hex("c") + "  music/new\\nline\\\\c",
+		"SHA256 (docs/d (1).txt) = " + sha256Hex("d"),
+	}, "\n")
+	manifest, err := ReadManifest(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Hasher != HasherSHA256 {
+		t.Errorf("hasher %s, want %s", manifest.Hasher, HasherSHA256)
+	}
+	wantHashes := map[string]string{
+		filepath.FromSlash("music/a.flac"):       sha256Hash("a"),
+		filepath.FromSlash("music/b.flac"):       sha256Hash("b"),
+		filepath.FromSlash("music/new\nline\\c"): sha256Hash("c"),
+		filepath.FromSlash("docs/d (1).txt"):     sha256Hash("d"),
+	}
+	if len(manifest.Hashes) != len(wantHashes) {
+		t.Errorf("hashes %v, want %v", manifest.Hashes, wantHashes)
+	}
+	for path, hash := range wantHashes {
+		if manifest.Hashes[path] != hash {
+			t.Errorf("hash of %q is %s, want %s", path, manifest.Hashes[path], hash)
+		}
+	}
+	// a size comment only records the size of the next file
+	wantSizes := map[string]int64{
+		filepath.FromSlash("music/a.flac"):       5,
+		filepath.FromSlash("music/new\nline\\c"): 7,
+	}
+	if len(manifest.Sizes) != len(wantSizes) {
+		t.Errorf("sizes %v, want %v", manifest.Sizes, wantSizes)
+	}
+	for path, size := range wantSizes {
+		if got, ok := manifest.Sizes[path]; !ok || got != size {
+			t.Errorf("size of %q is %d, want %d", path, got, size)
+		}
+	}
+
+	md5 := strings.Repeat("0", 32)
+	for name, input := range map[string]string{
+		"mixed hashes":     sha256Hex("a") + "  a\n" + md5 + "  b\n",
+		"unsupported hash": "abcd  a\n",
+		"invalid hex":      strings.Repeat("z", 64) + "  a\n",
+		"missing path":     sha256Hex("a") + "\n",
+		"invalid size":     "# size -1\n" + sha256Hex("a") + "  a\n",
+	} {
+		if _, err := ReadManifest(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: read without error", name)
+		}
+	}
+}
+
+func TestManifestHash(t *testing.T) {
+	written := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	manifest := &Manifest{
+		Hasher:  HasherSHA256,
+		ModTime: written,
+		Hashes:  map[string]string{"a": "AAAA", "b": "BBBB"},
+		Sizes:   map[string]int64{"a": 10},
+	}
+	tests := []struct {
+		name    string
+		path    string
+		size    int64
+		modTime time.Time
+		listed  bool
+	}{
+		{"recorded size", "a", 10, written.Add(-time.Hour), true},
+		{"modified with the manifest", "a", 10, written, true},
+		{"other size", "a", 11, written.Add(-time.Hour), false},
+		{"modified after the manifest", "a", 10, written.Add(time.Second), false},
+		{"no recorded size", "b", 10, written.Add(-time.Hour), false},
+		{"not listed", "c", 10, written.Add(-time.Hour), false},
+	}
+	for _, test := range tests {
+		hash, listed := manifest.Hash(test.path, test.size, test.modTime)
+		if listed != test.listed || (listed && hash != manifest.Hashes[test.path]) {
+			t.Errorf("%s: hash %q listed %v, want listed %v", test.name, hash, listed, test.listed)
+		}
+	}
+	if _, listed := (*Manifest)(nil).Hash("a", 10, written); listed {
+		t.Errorf("a missing manifest lists a hash")
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	storage := NewMemoryStorage()
+	for _, file := range []*File{
+		{Name: "b.flac", Path: "music/b.flac", Size: 3, Hash: sha256Hash("bbb")},
+		{Name: "a.flac", Path: "music/a.flac", Size: 2, Hash: sha256Hash("aa")},
+		{Name: "new\nline", Path: "docs/new\nline", Size: 1, Hash: sha256Hash("n")},
+	} {
+		if err := storage.AddFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var output bytes.Buffer
+	if err := WriteManifest(&output, storage, HasherSHA256); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"# size 1",
+		"\\" + sha256Hex("n") + "  docs/new\\nline",
+		"# size 2",
+		sha256Hex("aa") + "  music/a.flac",
+		"# size 3",
+		sha256Hex("bbb") + "  music/b.flac",
+		"",
+	}, "\n")
+	if output.String() != want {
+		t.Errorf("manifest:\n%s\nwant:\n%s", output.String(), want)
+	}
+
+	manifest, err := ReadManifest(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file := range storage.Walk(".") {
+		if manifest.Hashes[file.Path] != file.Hash || manifest.Sizes[file.Path] != file.Size {
+			t.Errorf("read back %s as %s of %d bytes, want %s of %d bytes",
+				file.Path, manifest.Hashes[file.Path], manifest.Sizes[file.Path], file.Hash, file.Size)
+		}
+	}
+
+	if err := WriteManifest(&output, storage, HasherMD5); !errors.Is(err, ErrNoFullHash) {
+		t.Errorf("wrote sha256 hashes as md5: %v", err)
+	}
+	if err := WriteManifest(&output, storage, HasherImohash); !errors.Is(err, ErrNoFullHash) {
+		t.Errorf("wrote a manifest of partial hashes: %v", err)
+	}
+}
+
+func TestScanSkipsManifestFiles(t *testing.T) {
+	root := t.TempDir()
+	contents := map[string]string{
+		"listed":   "listed content",
+		"resized":  "resized content",
+		"unsized":  "unsized content",
+		"modified": "modified content",
+		"other":    "other content",
+	}
+	for name, content := range contents {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	written := time.Now().Add(-time.Hour)
+	for name := range contents {
+		if name != "modified" {
+			if err := os.Chtimes(filepath.Join(root, name), written.Add(-time.Hour), written.Add(-time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// the manifest lists other hashes, so the files which are read are told apart
+	stale := sha256Hash("stale")
+	manifest := &Manifest{
+		Hasher:  HasherSHA256,
+		ModTime: written,
+		Hashes:  map[string]string{"listed": stale, "resized": stale, "unsized": stale, "modified": stale},
+		Sizes: map[string]int64{
+			"listed":   int64(len(contents["listed"])),
+			"resized":  int64(len(contents["resized"]) + 1),
+			"modified": int64(len(contents["modified"])),
+		},
+	}
+	storage := NewMemoryStorage()
+	scanner := &Scanner{Path: []string{root}, Storage: storage, Hasher: HasherSHA256, Manifest: manifest}
+	if err := scanner.Scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	hashes := map[string]string{}
+	for file := range storage.Walk(".") {
+		hashes[file.Path] = file.Hash
+	}
+	for name, content := range contents {
+		want := sha256Hash(content)
+		if name == "listed" {
+			want = stale
+		}
+		if hashes[name] != want {
+			t.Errorf("%s hashed %s, want %s", name, hashes[name], want)
+		}
+	}
+
+	scanner = &Scanner{Path: []string{root}, Storage: NewMemoryStorage(), Hasher: HasherMD5, Manifest: manifest}
+	if err := scanner.Scan(); err == nil {
+		t.Errorf("scanned with md5 hashes and a sha256 manifest")
+	}
+}
@@ -267,6 +267,11 @@ func (m *MergeFilePair) GetModified(index int) string {
 	return ""
 }
 
+// GetTagDiff returns the audio tags which differ between both files.
+func (m *MergeFilePair) GetTagDiff() []TagDifference {
+	return TagDiff(m.File1, m.File2)
+}
+
 func (m *MergeFilePair) SetAction(action MergeAction) {
 	if (action == ActionMoveToLeft || action == ActionDeleteRight) && m.File2 == nil {
 		m.Action = ActionNone
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Mount is a database loaded as a labeled top-level folder of a merged storage.
+type Mount struct {
+	Label string
+	// Root is the root path recorded in the database.
+	Root   string
+	Header DatabaseHeader
+	// Online is set when Root exists and matches the fingerprint of the database.
+	Online bool
+}
+
+// prefixStorage adds files below a folder of another storage.
+type prefixStorage struct {
+	Storage
+	prefix string
+}
+
+func (s *prefixStorage) AddFile(file *File) error {
+	file.Path = filepath.Join(s.prefix, file.Path)
+	return s.Storage.AddFile(file)
+}
+
+func (s *prefixStorage) AddFolder(path string, info FolderInfo) error {
+	return s.Storage.AddFolder(filepath.Join(s.prefix, path), info)
+}
+
+// MountDatabases loads every database into storage below a folder named by its label.
+// A database is given as label=path or as path, labeled by its file name.
+// All databases must use the same hasher. A remap with a label relocates the database
+// of that label, a remap without label the databases whose root is below its old root;
+// every remap must relocate a database.
+func MountDatabases(storage Storage, specs []string, hasher string, remaps ...RootRemap) ([]Mount, error) {
+	mounts := []Mount{}
+	used := make([]bool, len(remaps))
+	for _, spec := range specs {
+		label, path, ok := strings.Cut(spec, "=")
+		if !ok {
+			path = spec
+			label = strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), ".gz"), ".json")
+		}
+		if label == "" || label == "." || label == ".." || strings.ContainsRune(label, filepath.Separator) {
+			return nil, fmt.Errorf("invalid label %q for database %s", label, path)
+		}
+		for _, mount := range mounts {
+			if mount.Label == label {
+				return nil, fmt.Errorf("duplicate label %s", label)
+			}
+		}
+
+		header, err := LoadDatabase(path, &prefixStorage{Storage: storage, prefix: label}, "", hasher)
+		if err != nil {
+			return nil, err
+		}
+		mountRemaps := []RootRemap{}
+		for i, remap := range remaps {
+			if remap.Label == label || (remap.Label == "" && remap.matches(header.Roots)) {
+				mountRemaps = append(mountRemaps, remap)
+				used[i] = true
+			}
+		}
+		if err := header.Remap(mountRemaps...); err != nil {
+			return nil, fmt.Errorf("%s: %w", label, err)
+		}
+		if hasher == "" {
+			hasher = header.Hasher
+		}
+
+		mount := Mount{Label: label, Header: *header}
+		if len(header.Roots) > 0 {
+			mount.Root = header.Roots[0]
+			if info, err := os.Stat(mount.Root); err == nil && info.IsDir() {
+				mount.Online = header.VerifyFingerprint(mount.Root) == nil
+			}
+		}
+		mounts = append(mounts, mount)
+	}
+
+	for i, remap := range remaps {
+		if used[i] {
+			continue
+		}
+		if remap.Label != "" {
+			return nil, fmt.Errorf("remap for unknown database %s", remap.Label)
+		}
+		return nil, fmt.Errorf("%w: no database root is below %s", ErrRootMismatch, remap.From)
+	}
+	return mounts, nil
+}
+
+// FindMount returns the mount holding the storage path, or nil.
+func FindMount(mounts []Mount, path string) *Mount {
+	label, _, _ := strings.Cut(path, string(filepath.Separator))
+	for i := range mounts {
+		if mounts[i].Label == label {
+			return &mounts[i]
+		}
+	}
+	return nil
+}
+
+// taskMount returns the online mount all paths of the task belong to.
+func taskMount(mounts []Mount, task *FileActionTask) (*Mount, error) {
+	paths := []string{}
+	if task.File != nil {
+		paths = append(paths, task.File.Path)
+	}
+	if task.Folder != nil {
+		if FindMount(mounts, task.Folder.Path) != nil && !strings.ContainsRune(task.Folder.Path, filepath.Separator) {
+			return nil, fmt.Errorf("%s: cannot change the mounted folder %s", task.String(), task.Folder.Path)
+		}
+		paths = append(paths, task.Folder.Path)
+	}
+	if task.TargetFolder != nil {
+		paths = append(paths, task.TargetFolder.Path)
+	}
+
+	var mount *Mount
+	for _, path := range paths {
+		m := FindMount(mounts, path)
+		if m == nil {
+			return nil, fmt.Errorf("%s: %s is not inside a mounted database", task.String(), path)
+		}
+		if mount != nil && m != mount {
+			return nil, fmt.Errorf("%s: spans the databases %s and %s", task.String(), mount.Label, m.Label)
+		}
+		mount = m
+	}
+	if mount == nil {
+		return nil, fmt.Errorf("%s: no path", task.String())
+	}
+	if !mount.Online {
+		return nil, fmt.Errorf("%s: %s is offline", task.String(), mount.Label)
+	}
+	return mount, nil
+}
+
+// CheckMountedTasks verifies every task stays inside a single online mount.
+func CheckMountedTasks(mounts []Mount, tasks []FileActionTask) error {
+	for i := range tasks {
+		if _, err := taskMount(mounts, &tasks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
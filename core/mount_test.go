@@ -0,0 +1,57 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMountDatabasesRemaps(t *testing.T) {
+	dir := t.TempDir()
+	databases := map[string]string{"a": "/old/a", "b": "/other/b"}
+	specs := []string{}
+	for label, root := range databases {
+		storage := NewMemoryStorage()
+		if err := storage.AddFile(&File{Name: "f", Path: "f", Size: 1, Hash: "AAAA"}); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, label+".json")
+		if err := SaveDatabase(path, storage, DatabaseHeader{Roots: []string{root}}); err != nil {
+			t.Fatal(err)
+		}
+		specs = append(specs, label+"="+path)
+	}
+
+	tests := []struct {
+		remaps string
+		roots  map[string]string
+		fails  bool
+	}{
+		{"/old=/new", map[string]string{"a": "/new/a", "b": "/other/b"}, false},
+		{"b:/other=/x", map[string]string{"a": "/old/a", "b": "/x/b"}, false},
+		{"a:/old/a=/y,/other=/z", map[string]string{"a": "/y", "b": "/z/b"}, false},
+		{"c:/old=/new", nil, true},
+		{"/missing=/new", nil, true},
+		{"b:/old=/new", nil, true},
+	}
+	for _, test := range tests {
+		remaps, err := ParseRootRemaps(test.remaps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mounts, err := MountDatabases(NewMemoryStorage(), specs, "", remaps...)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: mounted, want an error", test.remaps)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.remaps, err)
+		}
+		for _, mount := range mounts {
+			if mount.Root != test.roots[mount.Label] {
+				t.Errorf("%s: %s root %s, want %s", test.remaps, mount.Label, mount.Root, test.roots[mount.Label])
+			}
+		}
+	}
+}
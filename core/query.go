@@ -0,0 +1,92 @@
+package core
+
+import (
+	"iter"
+	"path/filepath"
+	"strings"
+)
+
+// FindByHash returns all non-empty files with the given hash.
+func (s *MemoryStorage) FindByHash(hash string) ([]*File, error) {
+	lock := s.hashLock(hash)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if matchedPair, ok := s.matchedFiles.Load(hash); ok {
+		return append([]*File{}, matchedPair.(*MatchedFileGroup).Files...), nil
+	}
+	if file, ok := s.hashMap.Load(hash); ok {
+		return []*File{file.(*File)}, nil
+	}
+	return []*File{}, nil
+}
+
+// FindBySizeRange returns all files with minSize <= size <= maxSize.
+// A negative maxSize means no upper bound.
+func (s *MemoryStorage) FindBySizeRange(minSize int64, maxSize int64) ([]*File, error) {
+	files := []*File{}
+	for file := range s.Walk(".") {
+		if file.Size >= minSize && (maxSize < 0 || file.Size <= maxSize) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// FindByGlob returns all files below the folder at path matching the pattern.
+// The pattern is matched against the file name, or against the path relative
+// to the folder when it contains a path separator.
+func (s *MemoryStorage) FindByGlob(path string, pattern string) ([]*File, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	files := []*File{}
+	for file := range s.Walk(path) {
+		if matchGlob(pattern, path, file) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// Walk returns an iterator over all files in the folder at path and its subfolders.
+// The iterator is empty if the folder does not exist.
+func (s *MemoryStorage) Walk(path string) iter.Seq[*File] {
+	return func(yield func(*File) bool) {
+		value, ok := s.folders.Load(path)
+		if !ok {
+			return
+		}
+		walkFiles(value.(*Folder), yield)
+	}
+}
+
+// walkFiles yields every file in folder and its subfolders, returning false when stopped.
+func walkFiles(folder *Folder, yield func(*File) bool) bool {
+	for _, file := range folder.GetFiles() {
+		if !yield(file) {
+			return false
+		}
+	}
+	for _, subFolder := range folder.GetFolders() {
+		if !walkFiles(subFolder, yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchGlob reports whether the file below folder path matches the pattern.
+func matchGlob(pattern string, path string, file *File) bool {
+	name := file.Name
+	if strings.ContainsRune(pattern, filepath.Separator) {
+		relPath, err := filepath.Rel(path, file.Path)
+		if err != nil {
+			return false
+		}
+		name = relPath
+	}
+	matched, _ := filepath.Match(pattern, name)
+	return matched
+}
@@ -0,0 +1,171 @@
+package core
+
+import "path/filepath"
+
+// FolderRelation classifies a folder of a pair by the content of its target folder.
+type FolderRelation int
+
+const (
+	// PartialOverlap folders share some files, each holds files missing in the other.
+	PartialOverlap FolderRelation = iota
+	// Identical folders hold the same contents.
+	Identical
+	// Subset folders have a copy of each of their files in the target folder, so they can be deleted.
+	Subset
+	// Superset folders hold a copy of every file of the target folder, and more.
+	Superset
+)
+
+var folderRelationNames = []string{"partial overlap", "identical", "subset", "superset"}
+
+func (r FolderRelation) String() string {
+	return folderRelationNames[r]
+}
+
+// GetFolderRelation classifies the folder by the content of its target folder, counting
+// the files of their subfolders. Empty files are ignored like for the similarity, and
+// when a folder contains the other, the files of the inner folder are not counted in the outer one.
+// The relations are computed on demand and kept until the storage changes.
+func (s *SimilarityChecker) GetFolderRelation(folder *FolderSimilarity) FolderRelation {
+	target := folder.TargetFolder
+	key := [2]string{folder.path, target.path}
+	if relation, ok := s.relations[key]; ok {
+		return relation
+	}
+
+	relation := PartialOverlap
+	// a folder is only contained when every byte is counted as duplicate, unless the folders are
+	// nested, as the duplicates of the inner folder elsewhere in the outer one are not counted in the pair
+	nested := isSubPath(folder.path, target.path) || isSubPath(target.path, folder.path)
+	if nested || folder.DuplicateSize >= folder.TotalSize || target.DuplicateSize >= target.TotalSize {
+		hashes1 := s.folderHashes(folder.path, target.path)
+		hashes2 := s.folderHashes(target.path, folder.path)
+		inside1, inside2 := containsAll(hashes2, hashes1), containsAll(hashes1, hashes2)
+		switch {
+		case inside1 && inside2:
+			relation = Identical
+		case inside1:
+			relation = Subset
+		case inside2:
+			relation = Superset
+		}
+	}
+
+	if s.relations == nil {
+		s.relations = make(map[[2]string]FolderRelation)
+	}
+	s.relations[key] = relation
+	s.relations[[2]string{target.path, folder.path}] = inverseRelation(relation)
+	return relation
+}
+
+// IsRedundant reports whether the folder at path is identical to or a subset of another folder,
+// so every file in it has a copy elsewhere.
+func (s *SimilarityChecker) IsRedundant(path string) bool {
+	if redundant, ok := s.redundant[path]; ok {
+		return redundant
+	}
+
+	redundant := false
+	for _, key := range s.similarityFolderMap[path] {
+		pair, ok := s.similarityFolderPairs[key]
+		if !ok {
+			continue
+		}
+		folder := pair[0]
+		if folder.path != path {
+			folder = pair[1]
+		}
+		if relation := s.GetFolderRelation(folder); relation == Identical || relation == Subset {
+			redundant = true
+			break
+		}
+	}
+
+	if s.redundant == nil {
+		s.redundant = make(map[string]bool)
+	}
+	s.redundant[path] = redundant
+	return redundant
+}
+
+// ContainsRedundantFolder reports whether the folder at path or one of its subfolders is redundant.
+// The redundant folders and their ancestors are found once for every path until the storage changes.
+func (s *SimilarityChecker) ContainsRedundantFolder(path string) bool {
+	if s.containsRedundant == nil {
+		s.containsRedundant = make(map[string]bool)
+		for folder := range s.similarityFolderMap {
+			if !s.IsRedundant(folder) {
+				continue
+			}
+			for current := folder; !s.containsRedundant[current]; {
+				s.containsRedundant[current] = true
+				parent := filepath.Dir(current)
+				if parent == current {
+					break
+				}
+				current = parent
+			}
+		}
+	}
+	return s.containsRedundant[path]
+}
+
+// folderHashes returns the hashes of the non-empty files below the folder at path,
+// except the files below the folder at exclude. The set is walked once for every
+// folder until the storage changes and must not be modified.
+func (s *SimilarityChecker) folderHashes(path string, exclude string) map[string]bool {
+	nested := exclude != path && isSubPath(exclude, path)
+	if !nested {
+		exclude = path
+	}
+	key := [2]string{path, exclude}
+	if hashes, ok := s.hashes[key]; ok {
+		return hashes
+	}
+
+	hashes := map[string]bool{}
+	for file := range s.storage.Walk(path) {
+		if file.Size == 0 || (nested && isSubPath(file.Path, exclude)) {
+			continue
+		}
+		hashes[file.Hash] = true
+	}
+
+	if s.hashes == nil {
+		s.hashes = make(map[[2]string]map[string]bool)
+	}
+	s.hashes[key] = hashes
+	return hashes
+}
+
+// resetRelations drops the relations and folder hashes computed before the storage changed.
+func (s *SimilarityChecker) resetRelations() {
+	s.relations = nil
+	s.redundant = nil
+	s.containsRedundant = nil
+	s.hashes = nil
+}
+
+// containsAll reports whether every hash of subset is in hashes.
+func containsAll(hashes map[string]bool, subset map[string]bool) bool {
+	if len(subset) == 0 || len(subset) > len(hashes) {
+		return false
+	}
+	for hash := range subset {
+		if !hashes[hash] {
+			return false
+		}
+	}
+	return true
+}
+
+func inverseRelation(relation FolderRelation) FolderRelation {
+	switch relation {
+	case Subset:
+		return Superset
+	case Superset:
+		return Subset
+	}
+	return relation
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"fmt"
+	"iter"
+	"math/rand"
+	"testing"
+)
+
+func TestContainsRedundantFolder(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		storage := NewMemoryStorage()
+		for id := 0; id < 60; id++ {
+			hash := r.Intn(12)
+			file := &File{
+				Name: fmt.Sprintf("f%d", id),
+				Path: fmt.Sprintf("d%d/e%d/g%d/f%d", r.Intn(3), r.Intn(3), r.Intn(2), id),
+				Size: int64(100 * (hash + 1)),
+				Hash: fmt.Sprintf("AA%02d", hash),
+			}
+			if err := storage.AddFile(file); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checker := &SimilarityChecker{}
+		if err := checker.CalculateSimilarity(storage); err != nil {
+			t.Fatal(err)
+		}
+
+		root, err := storage.GetFolder(".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		redundant := 0
+		walkFolders(root, func(folder *Folder) {
+			want := false
+			for path := range checker.similarityFolderMap {
+				if isSubPath(path, folder.Path) && checker.IsRedundant(path) {
+					want = true
+				}
+			}
+			if checker.IsRedundant(folder.Path) {
+				redundant++
+			}
+			if got := checker.ContainsRedundantFolder(folder.Path); got != want {
+				t.Errorf("seed %d: %s contains a redundant folder: %v, want %v", seed, folder.Path, got, want)
+			}
+		})
+		if redundant == 0 {
+			t.Errorf("seed %d: no redundant folder", seed)
+		}
+		checker.Close()
+	}
+}
+
+// walkCountingStorage counts the walks of every folder.
+type walkCountingStorage struct {
+	*MemoryStorage
+	walks map[string]int
+}
+
+func (s walkCountingStorage) Walk(path string) iter.Seq[*File] {
+	s.walks[path]++
+	return s.MemoryStorage.Walk(path)
+}
+
+func TestFolderRelationWalksOnce(t *testing.T) {
+	storage := walkCountingStorage{MemoryStorage: NewMemoryStorage(), walks: map[string]int{}}
+	for _, folder := range []string{"a", "b", "c"} {
+		for i := 0; i < 3; i++ {
+			file := &File{Name: fmt.Sprintf("t%d", i), Path: fmt.Sprintf("%s/t%d", folder, i), Size: 100, Hash: fmt.Sprintf("AAA%d", i)}
+			if err := storage.AddFile(file); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	checker := &SimilarityChecker{}
+	if err := checker.CalculateSimilarity(storage); err != nil {
+		t.Fatal(err)
+	}
+	defer checker.Close()
+
+	relation := func(path1 string, path2 string) FolderRelation {
+		folder, _, err := getFolderSimilarity(path1, path2, checker.similarityFolderPairs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return checker.GetFolderRelation(folder)
+	}
+	for _, pair := range [][2]string{{"a", "b"}, {"a", "c"}, {"b", "c"}} {
+		if got := relation(pair[0], pair[1]); got != Identical {
+			t.Errorf("%s is %s to %s, want identical", pair[0], got, pair[1])
+		}
+	}
+	for _, folder := range []string{"a", "b", "c"} {
+		if storage.walks[folder] != 1 {
+			t.Errorf("%s walked %d times, want once", folder, storage.walks[folder])
+		}
+	}
+	// the clusters reuse the hashes and only list the files of their members
+	if clusters := checker.GetFolderClusters(50); len(clusters) != 1 {
+		t.Errorf("got %d clusters, want 1", len(clusters))
+	}
+	for _, folder := range []string{"a", "b", "c"} {
+		if storage.walks[folder] != 2 {
+			t.Errorf("%s walked %d times by the relations and clusters, want twice", folder, storage.walks[folder])
+		}
+	}
+
+	// the hashes are walked again once the storage changed
+	if err := storage.AddFile(&File{Name: "t9", Path: "b/t9", Size: 100, Hash: "AAA9"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.ApplyChanges(); err != nil {
+		t.Fatal(err)
+	}
+	if got := relation("a", "b"); got != Subset {
+		t.Errorf("a is %s to b after a file was added to b, want subset", got)
+	}
+	if storage.walks["b"] != 3 {
+		t.Errorf("b walked %d times, want once more after the change", storage.walks["b"])
+	}
+}
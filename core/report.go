@@ -0,0 +1,211 @@
+package core
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Columns of the duplicate group report.
+var GroupReportColumns = []string{"hash", "size", "count", "wasted_bytes", "paths"}
+
+// Columns of the folder pair report.
+var PairReportColumns = []string{
+	"path_a", "path_b",
+	"files_a", "files_b",
+	"duplicates_a", "duplicates_b",
+	"percent_a", "percent_b",
+	"reclaimable_a", "reclaimable_b",
+}
+
+// ReportOptions configures the CSV reports.
+type ReportOptions struct {
+	// Delimiter separates the fields, ',' when zero.
+	Delimiter rune
+	// Columns selects the columns to write, all columns when empty.
+	// Column names which do not belong to a report are ignored by it.
+	Columns []string
+}
+
+// Validate checks every selected column exists in one of the reports,
+// and every report has a selected column.
+func (o ReportOptions) Validate() error {
+	for _, column := range o.Columns {
+		if !slices.Contains(GroupReportColumns, column) && !slices.Contains(PairReportColumns, column) {
+			return fmt.Errorf("unknown report column %s", column)
+		}
+	}
+	if len(o.selectColumns(GroupReportColumns)) == 0 {
+		return fmt.Errorf("no column of the group report selected, among %s", strings.Join(GroupReportColumns, ","))
+	}
+	if len(o.selectColumns(PairReportColumns)) == 0 {
+		return fmt.Errorf("no column of the pair report selected, among %s", strings.Join(PairReportColumns, ","))
+	}
+	return nil
+}
+
+// selectColumns returns the columns of a report selected by the options.
+func (o ReportOptions) selectColumns(columns []string) []string {
+	if len(o.Columns) == 0 {
+		return columns
+	}
+	selected := []string{}
+	for _, column := range o.Columns {
+		if slices.Contains(columns, column) {
+			selected = append(selected, column)
+		}
+	}
+	return selected
+}
+
+func (o ReportOptions) newWriter(w io.Writer) *csv.Writer {
+	writer := csv.NewWriter(w)
+	if o.Delimiter != 0 {
+		writer.Comma = o.Delimiter
+	}
+	return writer
+}
+
+// WriteGroupsReport writes the matched file groups of storage as CSV, sorted by wasted bytes.
+func WriteGroupsReport(w io.Writer, storage Storage, options ReportOptions) error {
+	groups, err := storage.GetMatchedFiles()
+	if err != nil {
+		return err
+	}
+	wasted := make(map[string]int64, len(groups))
+	for _, group := range groups {
+		wasted[group.Hash] = group.WastedSize()
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		wi, wj := wasted[groups[i].Hash], wasted[groups[j].Hash]
+		if wi == wj {
+			return groups[i].Hash < groups[j].Hash
+		}
+		return wi > wj
+	})
+
+	columns := options.selectColumns(GroupReportColumns)
+	writer := options.newWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		size := group.Files[0].Size
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			switch column {
+			case "hash":
+				record[i] = group.Hash
+			case "size":
+				record[i] = strconv.FormatInt(size, 10)
+			case "count":
+				record[i] = strconv.Itoa(len(group.Files))
+			case "wasted_bytes":
+				record[i] = strconv.FormatInt(wasted[group.Hash], 10)
+			case "paths":
+				paths := make([]string, len(group.Files))
+				for j, file := range group.Files {
+					paths[j] = file.Path
+				}
+				sort.Strings(paths)
+				record[i] = strings.Join(paths, " | ")
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WritePairsReport writes the similar folder pairs of the checker as CSV, sorted by reclaimable bytes.
+func WritePairsReport(w io.Writer, checker *SimilarityChecker, options ReportOptions) error {
+	pairs := checker.GetSimilarityFolderPairs()
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return ReclaimableSize(pairs[i]) > ReclaimableSize(pairs[j])
+	})
+
+	columns := options.selectColumns(PairReportColumns)
+	writer := options.newWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+
+	for _, pair := range pairs {
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			switch column {
+			case "path_a":
+				record[i] = pair[0].Folder.Path
+			case "path_b":
+				record[i] = pair[1].Folder.Path
+			case "files_a":
+				record[i] = strconv.Itoa(pair[0].FileCount)
+			case "files_b":
+				record[i] = strconv.Itoa(pair[1].FileCount)
+			case "duplicates_a":
+				record[i] = strconv.Itoa(pair[0].DuplicateFileCount)
+			case "duplicates_b":
+				record[i] = strconv.Itoa(pair[1].DuplicateFileCount)
+			case "percent_a":
+				record[i] = strconv.FormatFloat(pair[0].DuplicatedPercentage(), 'f', 2, 64)
+			case "percent_b":
+				record[i] = strconv.FormatFloat(pair[1].DuplicatedPercentage(), 'f', 2, 64)
+			case "reclaimable_a":
+				record[i] = strconv.FormatInt(pair[0].DuplicateSize, 10)
+			case "reclaimable_b":
+				record[i] = strconv.FormatInt(pair[1].DuplicateSize, 10)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteReport writes <prefix>-groups.csv and <prefix>-pairs.csv and returns their paths.
+func WriteReport(prefix string, storage Storage, checker *SimilarityChecker, options ReportOptions) ([]string, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	groupsPath := prefix + "-groups.csv"
+	pairsPath := prefix + "-pairs.csv"
+
+	if err := writeReportFile(groupsPath, func(w io.Writer) error {
+		return WriteGroupsReport(w, storage, options)
+	}); err != nil {
+		return nil, err
+	}
+	if err := writeReportFile(pairsPath, func(w io.Writer) error {
+		return WritePairsReport(w, checker, options)
+	}); err != nil {
+		return nil, err
+	}
+
+	return []string{groupsPath, pairsPath}, nil
+}
+
+func writeReportFile(path string, write func(w io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := write(file); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGroupsReportCountsHardLinksOnce(t *testing.T) {
+	storage := NewMemoryStorage()
+	for _, file := range []*File{
+		{Name: "a", Path: "music/a", Size: 100, Hash: "AAAA", Device: 1, Inode: 10},
+		{Name: "a", Path: "backup/a", Size: 100, Hash: "AAAA", Device: 1, Inode: 10},
+		{Name: "a", Path: "copy/a", Size: 100, Hash: "AAAA", Device: 1, Inode: 11},
+		{Name: "b", Path: "music/b", Size: 300, Hash: "BBBB", Device: 1, Inode: 20},
+		{Name: "b", Path: "backup/b", Size: 300, Hash: "BBBB", Device: 1, Inode: 20},
+		{Name: "c", Path: "music/c", Size: 50, Hash: "CCCC"},
+		{Name: "c", Path: "backup/c", Size: 50, Hash: "CCCC"},
+	} {
+		if err := storage.AddFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var output bytes.Buffer
+	options := ReportOptions{Columns: []string{"hash", "count", "wasted_bytes"}}
+	if err := WriteGroupsReport(&output, storage, options); err != nil {
+		t.Fatal(err)
+	}
+	want := "hash,count,wasted_bytes\nAAAA,3,100\nCCCC,2,50\nBBBB,2,0\n"
+	if output.String() != want {
+		t.Errorf("report:\n%s\nwant:\n%s", output.String(), want)
+	}
+
+	stats, err := CalculateStats(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ReclaimableBytes != 150 {
+		t.Errorf("reclaimable %d bytes, want the 150 wasted bytes of the report", stats.ReclaimableBytes)
+	}
+}
+
+func TestReportOptionsValidate(t *testing.T) {
+	tests := []struct {
+		columns []string
+		err     string
+	}{
+		{nil, ""},
+		{[]string{"hash", "path_a"}, ""},
+		{[]string{"hash", "unknown"}, "unknown report column"},
+		{[]string{"hash", "size"}, "pair report"},
+		{[]string{"path_a"}, "group report"},
+	}
+	for _, test := range tests {
+		err := ReportOptions{Columns: test.columns}.Validate()
+		if test.err == "" && err != nil {
+			t.Errorf("%v: %v", test.columns, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%v: error %v, want %q", test.columns, err, test.err)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/kalafut/imohash"
 )
@@ -14,13 +16,54 @@ type Scanner struct {
 	Storage Storage
 	Logger  func(message string)
 	Context context.Context
+	// Hasher selects the hashing mode, HasherImohash when empty.
+	Hasher string
+	// ScanTime records when the last scan started.
+	ScanTime time.Time
+	// Manifest provides the hashes of listed files of the recorded size, which are not read.
+	// Its hasher must be the hasher of the scan.
+	Manifest *Manifest
+}
+
+// Header returns the database header describing the scan.
+func (s *Scanner) Header() DatabaseHeader {
+	roots := []string{}
+	for _, path := range s.Path {
+		if absPath, err := filepath.Abs(path); err == nil {
+			roots = append(roots, absPath)
+		}
+	}
+	hasher := s.Hasher
+	if hasher == "" {
+		hasher = HasherImohash
+	}
+	return DatabaseHeader{
+		Version:  DatabaseVersion,
+		Roots:    roots,
+		Hasher:   hasher,
+		ScanTime: s.ScanTime,
+		Options: map[string]string{
+			"skip_hidden": "true",
+		},
+	}
 }
 
 func (s *Scanner) Scan() error {
 	if s.Context == nil {
 		s.Context = context.Background()
 	}
+	s.ScanTime = time.Now()
 	hasher := imohash.New()
+	switch s.Hasher {
+	case "":
+		s.Hasher = HasherImohash
+	case HasherImohash, HasherAudio, HasherSHA256, HasherMD5:
+	default:
+		return fmt.Errorf("unknown hasher %s", s.Hasher)
+	}
+	if s.Manifest != nil && s.Manifest.Hasher != s.Hasher {
+		return fmt.Errorf("manifest hashes are %s, scanning with %s (use -hash %s)", s.Manifest.Hasher, s.Hasher, s.Manifest.Hasher)
+	}
 
 	for _, path := range s.Path {
 		root, err := os.OpenRoot(path)
@@ -38,7 +81,18 @@ func (s *Scanner) Scan() error {
 			if err != nil {
 				return err
 			}
-			if d.IsDir() || d.Name()[0] == '.' {
+			if d.IsDir() {
+				// record every directory, so empty ones are kept
+				info, err := d.Info()
+				if err != nil {
+					return fmt.Errorf("failed to stat directory %s: %w", path, err)
+				}
+				if err := s.Storage.AddFolder(path, newFolderInfo(info)); err != nil {
+					return fmt.Errorf("failed to add folder %s: %w", path, err)
+				}
+				return nil
+			}
+			if d.Name()[0] == '.' {
 				return nil
 			}
 
@@ -53,17 +107,25 @@ func (s *Scanner) Scan() error {
 				return fmt.Errorf("failed to stat file %s: %w", path, err)
 			}
 
-			hash, err := getFileHash(f, hasher)
-			if err != nil {
-				return fmt.Errorf("failed to hash file %s: %w", path, err)
+			hash, listed := s.Manifest.Hash(path, stats.Size(), stats.ModTime())
+			var tags map[string]string
+			if !listed {
+				hash, tags, err = hashFile(f, s.Hasher, hasher)
+				if err != nil {
+					return fmt.Errorf("failed to hash file %s: %w", path, err)
+				}
 			}
 
+			device, inode := fileLink(stats)
 			s.Storage.AddFile(&File{
 				Path:    path,
 				Hash:    hash,
 				Size:    stats.Size(),
 				ModTime: stats.ModTime(),
 				Name:    stats.Name(),
+				Tags:    tags,
+				Device:  device,
+				Inode:   inode,
 			})
 
 			if s.Logger != nil {
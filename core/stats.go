@@ -0,0 +1,194 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Stats summarizes the files of a storage and the space wasted by duplicates.
+type Stats struct {
+	Files           int
+	Bytes           int64
+	DuplicateGroups int
+	// RedundantCopies counts the copies beyond the first of every duplicate group.
+	RedundantCopies int
+	// ReclaimableBytes is the space freed by deleting the redundant copies,
+	// size × (copies − 1) per group. Hard links of a copy are not counted.
+	ReclaimableBytes int64
+	// Extensions breaks the numbers down by lower case file extension, "" for none.
+	Extensions []StatsBreakdown
+	// Folders breaks the numbers down by top-level folder, "." for files in the root.
+	Folders []StatsBreakdown
+}
+
+// StatsBreakdown holds the numbers of one extension or folder.
+type StatsBreakdown struct {
+	Name             string
+	Files            int
+	Bytes            int64
+	RedundantCopies  int
+	ReclaimableBytes int64
+}
+
+// CalculateStats computes the statistics of every file in storage.
+// The first copy of a duplicate group by path is kept, the others are
+// accounted as redundant to their extension and top-level folder.
+func CalculateStats(storage Storage) (*Stats, error) {
+	stats := &Stats{}
+	extensions := map[string]*StatsBreakdown{}
+	folders := map[string]*StatsBreakdown{}
+	breakdowns := func(file *File) [2]*StatsBreakdown {
+		return [2]*StatsBreakdown{
+			statsBreakdown(extensions, strings.ToLower(filepath.Ext(file.Name))),
+			statsBreakdown(folders, topLevelFolder(file.Path)),
+		}
+	}
+
+	for file := range storage.Walk(".") {
+		stats.Files++
+		stats.Bytes += file.Size
+		for _, breakdown := range breakdowns(file) {
+			breakdown.Files++
+			breakdown.Bytes += file.Size
+		}
+	}
+
+	groups, err := storage.GetMatchedFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		copies := group.DistinctCopies()
+		for _, file := range copies[min(1, len(copies)):] {
+			stats.RedundantCopies++
+			stats.ReclaimableBytes += file.Size
+			for _, breakdown := range breakdowns(file) {
+				breakdown.RedundantCopies++
+				breakdown.ReclaimableBytes += file.Size
+			}
+		}
+		if len(copies) > 1 {
+			stats.DuplicateGroups++
+		}
+	}
+
+	stats.Extensions = sortStatsBreakdowns(extensions)
+	stats.Folders = sortStatsBreakdowns(folders)
+	return stats, nil
+}
+
+// DistinctCopies returns the files of the group sorted by path, without the hard
+// links of a file already listed, which share its storage.
+func (g *MatchedFileGroup) DistinctCopies() []*File {
+	sorted := append([]*File{}, g.Files...)
+	sortFilesByPath(sorted)
+
+	links := map[[2]uint64]bool{}
+	copies := []*File{}
+	for _, file := range sorted {
+		if file.Inode != 0 {
+			link := [2]uint64{file.Device, file.Inode}
+			if links[link] {
+				continue
+			}
+			links[link] = true
+		}
+		copies = append(copies, file)
+	}
+	return copies
+}
+
+// WastedSize returns the space freed by deleting all copies of the group but one,
+// counting hard links once like Stats.ReclaimableBytes.
+func (g *MatchedFileGroup) WastedSize() int64 {
+	copies := g.DistinctCopies()
+	if len(copies) < 2 {
+		return 0
+	}
+	return copies[0].Size * int64(len(copies)-1)
+}
+
+// Summary returns the totals on a single line.
+func (s *Stats) Summary() string {
+	return fmt.Sprintf("%d files (%s), %d duplicate groups, %d redundant copies, %s reclaimable",
+		s.Files, FormatFileSize(s.Bytes), s.DuplicateGroups, s.RedundantCopies, FormatFileSize(s.ReclaimableBytes))
+}
+
+// String returns the numbers of the breakdown on a single line.
+func (b StatsBreakdown) String() string {
+	name := b.Name
+	if name == "" {
+		name = "(none)"
+	}
+	return fmt.Sprintf("%s: %d files (%s), %d redundant copies, %s reclaimable",
+		name, b.Files, FormatFileSize(b.Bytes), b.RedundantCopies, FormatFileSize(b.ReclaimableBytes))
+}
+
+// WriteText writes the totals and the breakdowns, at most limit entries each when limit > 0.
+func (s *Stats) WriteText(w io.Writer, limit int) error {
+	lines := []string{s.Summary()}
+	sections := []struct {
+		title      string
+		breakdowns []StatsBreakdown
+	}{
+		{"By extension", s.Extensions},
+		{"By top-level folder", s.Folders},
+	}
+	for _, section := range sections {
+		breakdowns := section.breakdowns
+		if limit > 0 && len(breakdowns) > limit {
+			breakdowns = breakdowns[:limit]
+		}
+		lines = append(lines, fmt.Sprintf("%s (%d):", section.title, len(section.breakdowns)))
+		for _, breakdown := range breakdowns {
+			lines = append(lines, "  "+breakdown.String())
+		}
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func statsBreakdown(breakdowns map[string]*StatsBreakdown, name string) *StatsBreakdown {
+	breakdown, ok := breakdowns[name]
+	if !ok {
+		breakdown = &StatsBreakdown{Name: name}
+		breakdowns[name] = breakdown
+	}
+	return breakdown
+}
+
+// sortStatsBreakdowns returns the breakdowns by reclaimable bytes, then size, largest first.
+func sortStatsBreakdowns(breakdowns map[string]*StatsBreakdown) []StatsBreakdown {
+	output := make([]StatsBreakdown, 0, len(breakdowns))
+	for _, breakdown := range breakdowns {
+		output = append(output, *breakdown)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].ReclaimableBytes != output[j].ReclaimableBytes {
+			return output[i].ReclaimableBytes > output[j].ReclaimableBytes
+		}
+		if output[i].Bytes != output[j].Bytes {
+			return output[i].Bytes > output[j].Bytes
+		}
+		return output[i].Name < output[j].Name
+	})
+	return output
+}
+
+// topLevelFolder returns the first folder of a path, "." for a file in the root.
+func topLevelFolder(path string) string {
+	dir := filepath.Dir(path)
+	if dir == "." || dir == string(filepath.Separator) {
+		return "."
+	}
+	first, _, _ := strings.Cut(strings.TrimPrefix(dir, string(filepath.Separator)), string(filepath.Separator))
+	return first
+}
@@ -3,8 +3,10 @@
 package core
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"iter"
 	"path/filepath"
 	"slices"
 	"sync"
@@ -13,29 +15,66 @@ import (
 // Storage interface defines methods for storing and retrieving file and folder data.
 type Storage interface {
 	AddFile(file *File) error
+	AddFiles(files []*File) error
+	AddFolder(path string, info FolderInfo) error
 	GetFolder(path string) (*Folder, error)
 	GetMatchedFiles() ([]*MatchedFileGroup, error)
 	RemoveFile(file *File) error
+	RemoveFolder(path string) error
+	MoveFolder(src string, dstParent string) error
+
+	FindByHash(hash string) ([]*File, error)
+	FindBySizeRange(minSize int64, maxSize int64) ([]*File, error)
+	FindByGlob(path string, pattern string) ([]*File, error)
+	Walk(path string) iter.Seq[*File]
+
+	Subscribe(fn func(event ChangeEvent)) func()
 }
 
+// hashLockCount is the number of lock stripes guarding the matched file groups.
+const hashLockCount = 256
+
 // MemoryStorage implements Storage using in-memory data structures.
+// It is safe for concurrent use by multiple writers.
 type MemoryStorage struct {
-	folders      sync.Map
-	matchedFiles sync.Map
-	hashMap      sync.Map
+	folders      storeMap
+	matchedFiles storeMap
+	hashMap      storeMap
+	// hashLocks serialize updates of the same hash, striped by hash value.
+	hashLocks [hashLockCount]sync.Mutex
+	// subscribers are notified of every change.
+	subscribers changeSubscribers
 }
 
 var _ Storage = &MemoryStorage{}
 
 // RemoveFile removes a file from storage.
 func (s *MemoryStorage) RemoveFile(file *File) error {
+	parentFolder, err := s.removeFile(file)
+	if err != nil {
+		return err
+	}
+	s.publish(ChangeEvent{Type: FileRemoved, File: file, Folder: parentFolder})
+	return nil
+}
 
+// removeFile removes a file from storage without publishing the change and returns its former parent.
+func (s *MemoryStorage) removeFile(file *File) (*Folder, error) {
 	parentFolder, err := s.GetFolder(filepath.Dir(file.Path))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	parentFolder.RemoveFile(file)
 
+	return parentFolder, s.removeHash(file)
+}
+
+// removeHash removes the file from the matched file groups.
+func (s *MemoryStorage) removeHash(file *File) error {
+	lock := s.hashLock(file.Hash)
+	lock.Lock()
+	defer lock.Unlock()
+
 	if matchedPair, ok := s.matchedFiles.Load(file.Hash); ok {
 		pair := matchedPair.(*MatchedFileGroup)
 		pair.Files = slices.DeleteFunc(pair.Files, func(f *File) bool {
@@ -58,20 +97,194 @@ func (s *MemoryStorage) RemoveFile(file *File) error {
 	return nil
 }
 
+// RemoveFolder removes a folder with all its files and subfolders from storage.
+func (s *MemoryStorage) RemoveFolder(path string) error {
+	folder, err := s.removeFolder(path)
+	if err != nil {
+		return err
+	}
+	s.publish(ChangeEvent{Type: FolderRemoved, Folder: folder, Path: path})
+	return nil
+}
+
+// removeFolder removes a folder from storage without publishing the change and returns it.
+func (s *MemoryStorage) removeFolder(path string) (*Folder, error) {
+	value, ok := s.folders.Load(path)
+	if !ok {
+		return nil, fmt.Errorf("folder %s not found", path)
+	}
+	folder := value.(*Folder)
+	if folder.Parent == nil {
+		return nil, fmt.Errorf("cannot remove root folder %s", path)
+	}
+
+	var err error
+	walkFolders(folder, func(f *Folder) {
+		f.files.Range(func(key, value interface{}) bool {
+			if removeErr := s.removeHash(value.(*File)); removeErr != nil && err == nil {
+				err = removeErr
+			}
+			return true
+		})
+		s.folders.Delete(f.Path)
+	})
+
+	folder.Parent.Folders.Delete(folder.Name)
+	folder.Parent.invalidateCache()
+	folder.Parent = nil
+	return folder, err
+}
+
+// MoveFolder moves a folder with all its files and subfolders into the folder dstParent.
+func (s *MemoryStorage) MoveFolder(src string, dstParent string) error {
+	folder, err := s.moveFolder(src, dstParent)
+	if err != nil {
+		return err
+	}
+	s.publish(ChangeEvent{Type: FolderMoved, Folder: folder, Path: src})
+	return nil
+}
+
+// moveFolder moves a folder without publishing the change and returns it.
+func (s *MemoryStorage) moveFolder(src string, dstParent string) (*Folder, error) {
+	value, ok := s.folders.Load(src)
+	if !ok {
+		return nil, fmt.Errorf("folder %s not found", src)
+	}
+	folder := value.(*Folder)
+	if folder.Parent == nil {
+		return nil, fmt.Errorf("cannot move root folder %s", src)
+	}
+
+	dst := filepath.Join(dstParent, folder.Name)
+	if isSubPath(dstParent, src) {
+		return nil, fmt.Errorf("cannot move folder %s into itself", src)
+	}
+	if _, ok := s.folders.Load(dst); ok {
+		return nil, fmt.Errorf("target folder %s already exists", dst)
+	}
+
+	parentFolder, err := s.GetFolder(dstParent)
+	if err != nil {
+		return nil, err
+	}
+
+	folder.Parent.Folders.Delete(folder.Name)
+	folder.Parent.invalidateCache()
+	folder.Parent = parentFolder
+	parentFolder.Folders.Store(folder.Name, folder)
+
+	// update the path of every folder and file below the moved folder
+	walkFolders(folder, func(f *Folder) {
+		s.folders.Delete(f.Path)
+		if f != folder {
+			f.Path = filepath.Join(f.Parent.Path, f.Name)
+		} else {
+			f.Path = dst
+		}
+		s.folders.Store(f.Path, f)
+		f.files.Range(func(key, value interface{}) bool {
+			file := value.(*File)
+			file.Path = filepath.Join(f.Path, file.Name)
+			return true
+		})
+	})
+	parentFolder.invalidateCache()
+
+	return folder, nil
+}
+
 // AddFile adds a file to storage.
 func (s *MemoryStorage) AddFile(file *File) error {
+	if err := s.addFile(file); err != nil {
+		return err
+	}
+	s.publish(ChangeEvent{Type: FileAdded, File: file, Folder: file.Parent})
+	return nil
+}
+
+// addFile adds a file to storage without publishing the change.
+func (s *MemoryStorage) addFile(file *File) error {
 	parentFolder, err := s.GetFolder(filepath.Dir(file.Path))
 	if err != nil {
 		return err
 	}
 
 	parentFolder.AddFile(file)
+	s.addHash(file)
+
+	return nil
+}
+
+// AddFolder records a folder with its metadata, creating it if it doesn't exist.
+// The folder is kept even when it holds no files.
+func (s *MemoryStorage) AddFolder(path string, info FolderInfo) error {
+	folder, err := s.GetFolder(path)
+	if err != nil {
+		return err
+	}
+	folder.Info = &info
+	s.publish(ChangeEvent{Type: FolderAdded, Folder: folder})
+	return nil
+}
+
+// AddFiles adds a batch of files to storage. It may be called concurrently.
+// The matched file groups are updated one lock stripe at a time, each stripe
+// is locked once for all the files of the batch it guards.
+func (s *MemoryStorage) AddFiles(files []*File) error {
+	errs := []error{}
+	added := []*File{}
+	stripes := map[*sync.Mutex][]*File{}
+	for _, file := range files {
+		parentFolder, err := s.GetFolder(filepath.Dir(file.Path))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to add file %s: %w", file.Path, err))
+			continue
+		}
+		parentFolder.AddFile(file)
+		added = append(added, file)
+		if file.Size > 0 {
+			lock := s.hashLock(file.Hash)
+			stripes[lock] = append(stripes[lock], file)
+		}
+	}
+
+	for lock, files := range stripes {
+		lock.Lock()
+		for _, file := range files {
+			s.addHashLocked(file)
+		}
+		lock.Unlock()
+	}
+
+	for _, file := range added {
+		s.publish(ChangeEvent{Type: FileAdded, File: file, Folder: file.Parent})
+	}
+	return errors.Join(errs...)
+}
+
+// hashLock returns the lock stripe guarding the given hash.
+func (s *MemoryStorage) hashLock(hash string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(hash))
+	return &s.hashLocks[h.Sum32()%hashLockCount]
+}
 
+// addHash records the file hash and updates the matched file groups.
+func (s *MemoryStorage) addHash(file *File) {
 	// skip file if empty
 	if file.Size == 0 {
-		return nil
+		return
 	}
 
+	lock := s.hashLock(file.Hash)
+	lock.Lock()
+	defer lock.Unlock()
+	s.addHashLocked(file)
+}
+
+// addHashLocked updates the matched file groups with a non-empty file, holding the lock stripe of its hash.
+func (s *MemoryStorage) addHashLocked(file *File) {
 	// Record the file hash to fileHashMap
 	if matchedFile, ok := s.hashMap.Load(file.Hash); !ok {
 		s.hashMap.Store(file.Hash, file)
@@ -88,8 +301,6 @@ func (s *MemoryStorage) AddFile(file *File) error {
 			s.matchedFiles.Store(file.Hash, newMatchedFile)
 		}
 	}
-
-	return nil
 }
 
 // GetFolder retrieves a folder by path, creating it if it doesn't exist.
@@ -102,8 +313,8 @@ func (s *MemoryStorage) GetFolder(path string) (*Folder, error) {
 			Path: path,
 		}
 
-		s.folders.Store(path, rootFolder)
-		return rootFolder, nil
+		f, _ := s.folders.LoadOrStore(path, rootFolder)
+		return f.(*Folder), nil
 	}
 
 	parentPath := filepath.Dir(path)
@@ -117,10 +328,12 @@ func (s *MemoryStorage) GetFolder(path string) (*Folder, error) {
 		Path:   path,
 		Parent: parentFolder,
 	}
-	parentFolder.Folders.Store(filepath.Base(path), newFolder)
 
-	// Store new folder in memory storage
-	s.folders.Store(path, newFolder)
+	// Store new folder in memory storage, unless a concurrent call created it first
+	if f, loaded := s.folders.LoadOrStore(path, newFolder); loaded {
+		return f.(*Folder), nil
+	}
+	parentFolder.Folders.Store(filepath.Base(path), newFolder)
 	return newFolder, nil
 }
 
@@ -136,23 +349,13 @@ func (s *MemoryStorage) GetMatchedFiles() ([]*MatchedFileGroup, error) {
 	return matchedFiles, nil
 }
 
-func (s *MemoryStorage) ExportStorage() ([]byte, error) {
-	files := []File{}
-	s.hashMap.Range(func(key, value interface{}) bool {
-		if matched, ok := s.matchedFiles.Load(key); ok {
-			for _, file := range matched.(*MatchedFileGroup).Files {
-				files = append(files, *file)
-			}
-		} else {
-			files = append(files, *value.(*File))
-		}
+// walkFolders calls fn for folder and all its subfolders, parents first.
+func walkFolders(folder *Folder, fn func(f *Folder)) {
+	fn(folder)
+	folder.Folders.Range(func(key, value interface{}) bool {
+		walkFolders(value.(*Folder), fn)
 		return true
 	})
-	for i, _ := range files {
-		files[i].Parent = nil
-	}
-
-	return json.Marshal(files)
 }
 
 // NewMemoryStorage creates a new memory storage instance.
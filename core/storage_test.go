@@ -0,0 +1,116 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestMemoryStorageConcurrentAdd adds files sharing folders and hashes from several goroutines,
+// one file at a time and in batches, and checks every matched group holds exactly its files.
+// Run it with -race.
+func TestMemoryStorageConcurrentAdd(t *testing.T) {
+	const writers, perWriter, hashes = 8, 500, 300
+
+	storage := NewMemoryStorage()
+	files := make([][]*File, writers)
+	for w := range files {
+		for i := 0; i < perWriter; i++ {
+			hash := (w*perWriter + i*7) % hashes
+			files[w] = append(files[w], &File{
+				Name: fmt.Sprintf("w%d-%d", w, i),
+				Path: fmt.Sprintf("d%d/e%d/w%d-%d", i%5, i%3, w, i),
+				Size: int64(hash % 50),
+				Hash: fmt.Sprintf("H%03d", hash),
+			})
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, writers)
+	for w := range files {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			if w%2 == 0 {
+				for _, file := range files[w] {
+					if err := storage.AddFile(file); err != nil {
+						errs[w] = err
+						return
+					}
+				}
+				return
+			}
+			for i := 0; i < perWriter; i += 50 {
+				if err := storage.AddFiles(files[w][i : i+50]); err != nil {
+					errs[w] = err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]map[*File]bool{}
+	for _, batch := range files {
+		for _, file := range batch {
+			if file.Size == 0 {
+				continue
+			}
+			if want[file.Hash] == nil {
+				want[file.Hash] = map[*File]bool{}
+			}
+			want[file.Hash][file] = true
+		}
+	}
+
+	groups, err := storage.GetMatchedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, group := range groups {
+		if seen[group.Hash] {
+			t.Errorf("hash %s is matched twice", group.Hash)
+		}
+		seen[group.Hash] = true
+		got := map[*File]bool{}
+		for _, file := range group.Files {
+			if got[file] {
+				t.Errorf("hash %s holds %s twice", group.Hash, file.Path)
+			}
+			got[file] = true
+			if !want[group.Hash][file] {
+				t.Errorf("hash %s holds %s", group.Hash, file.Path)
+			}
+		}
+		if len(got) != len(want[group.Hash]) {
+			t.Errorf("hash %s holds %d files, want %d", group.Hash, len(got), len(want[group.Hash]))
+		}
+	}
+	for hash, hashFiles := range want {
+		if len(hashFiles) >= 2 && !seen[hash] {
+			t.Errorf("hash %s with %d files is not matched", hash, len(hashFiles))
+		}
+		found, err := storage.FindByHash(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != len(hashFiles) {
+			t.Errorf("found %d files with hash %s, want %d", len(found), hash, len(hashFiles))
+		}
+	}
+
+	root, err := storage.GetFolder(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := root.GetFileCount(); got != writers*perWriter {
+		t.Errorf("root holds %d files, want %d", got, writers*perWriter)
+	}
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// storeMap is a map safe for concurrent use, with the methods of sync.Map used by the storages.
+// It starts as a sync.Map, which suits the concurrent writers of a scan, and freeze moves
+// its entries to a plain map guarded by a lock, which takes less memory per entry.
+type storeMap struct {
+	m     sync.Map
+	plain atomic.Pointer[plainMap]
+}
+
+// plainMap holds the entries of a frozen storeMap.
+type plainMap struct {
+	mu      sync.RWMutex
+	entries map[any]any
+}
+
+func (m *storeMap) Load(key any) (any, bool) {
+	if p := m.plain.Load(); p != nil {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		value, ok := p.entries[key]
+		return value, ok
+	}
+	return m.m.Load(key)
+}
+
+func (m *storeMap) Store(key any, value any) {
+	if p := m.plain.Load(); p != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.entries[key] = value
+		return
+	}
+	m.m.Store(key, value)
+}
+
+func (m *storeMap) LoadOrStore(key any, value any) (any, bool) {
+	if p := m.plain.Load(); p != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if actual, ok := p.entries[key]; ok {
+			return actual, true
+		}
+		p.entries[key] = value
+		return value, false
+	}
+	return m.m.LoadOrStore(key, value)
+}
+
+func (m *storeMap) Delete(key any) {
+	if p := m.plain.Load(); p != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.entries, key)
+		return
+	}
+	m.m.Delete(key)
+}
+
+// Range calls f for every entry until f returns false. Like sync.Map, f may change the map;
+// a frozen map is iterated over a copy of its entries.
+func (m *storeMap) Range(f func(key any, value any) bool) {
+	p := m.plain.Load()
+	if p == nil {
+		m.m.Range(f)
+		return
+	}
+
+	p.mu.RLock()
+	entries := make([][2]any, 0, len(p.entries))
+	for key, value := range p.entries {
+		entries = append(entries, [2]any{key, value})
+	}
+	p.mu.RUnlock()
+	for _, entry := range entries {
+		if !f(entry[0], entry[1]) {
+			return
+		}
+	}
+}
+
+// freeze moves the entries to a plain map. It must not run concurrently with writes.
+func (m *storeMap) freeze() {
+	if m.plain.Load() != nil {
+		return
+	}
+	p := &plainMap{entries: make(map[any]any)}
+	m.m.Range(func(key, value any) bool {
+		p.entries[key] = value
+		return true
+	})
+	m.m.Clear()
+	m.plain.Store(p)
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SimilarityThreshold selects the folder pairs reported by the similarity checker.
+// The zero value reports every pair sharing a file.
+type SimilarityThreshold struct {
+	// MinFiles is the minimum number of duplicate files on both sides.
+	MinFiles int
+	// MinSize is the minimum number of duplicate bytes on both sides.
+	MinSize int64
+	// MinPercentage is the minimum similarity by the metric, on either side
+	// unless BothSides is set.
+	MinPercentage float64
+	BothSides     bool
+}
+
+// Accept reports whether the pair of folder and its target folder passes the threshold.
+func (t SimilarityThreshold) Accept(folder *FolderSimilarity, metric SimilarityMetric) bool {
+	target := folder.TargetFolder
+	if min(folder.DuplicateFileCount, target.DuplicateFileCount) < t.MinFiles {
+		return false
+	}
+	if min(folder.DuplicateSize, target.DuplicateSize) < t.MinSize {
+		return false
+	}
+	if t.MinPercentage <= 0 {
+		return true
+	}
+
+	passed1 := folder.Similarity(metric) >= t.MinPercentage
+	passed2 := target.Similarity(metric) >= t.MinPercentage
+	if t.BothSides {
+		return passed1 && passed2
+	}
+	return passed1 || passed2
+}
+
+// String describes the threshold on a single line.
+func (t SimilarityThreshold) String() string {
+	parts := []string{}
+	if t.MinFiles > 0 {
+		parts = append(parts, fmt.Sprintf("%d files", t.MinFiles))
+	}
+	if t.MinSize > 0 {
+		parts = append(parts, FormatFileSize(t.MinSize))
+	}
+	if t.MinPercentage > 0 {
+		side := "either side"
+		if t.BothSides {
+			side = "both sides"
+		}
+		parts = append(parts, fmt.Sprintf("%.0f%% on %s", t.MinPercentage, side))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return "at least " + strings.Join(parts, ", ")
+}
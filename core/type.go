@@ -3,6 +3,7 @@
 package core
 
 import (
+	"io/fs"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,8 +15,33 @@ type File struct {
 	Path    string
 	Hash    string
 	Size    int64
-	Parent  *Folder
+	Parent  *Folder `json:"-"`
 	ModTime time.Time
+	// Tags holds the audio tags stripped by the audio hasher, if any.
+	Tags map[string]string `json:",omitempty"`
+	// Device and Inode identify a file with more than one hard link, zero otherwise.
+	Device uint64 `json:",omitempty"`
+	Inode  uint64 `json:",omitempty"`
+}
+
+// FolderInfo holds the metadata of a scanned directory.
+type FolderInfo struct {
+	ModTime time.Time
+	Mode    fs.FileMode
+	// UID and GID identify the owner on Unix, zero otherwise.
+	UID uint32 `json:",omitempty"`
+	GID uint32 `json:",omitempty"`
+}
+
+// newFolderInfo returns the metadata of a directory.
+func newFolderInfo(info fs.FileInfo) FolderInfo {
+	uid, gid := fileOwner(info)
+	return FolderInfo{
+		ModTime: info.ModTime(),
+		Mode:    info.Mode(),
+		UID:     uid,
+		GID:     gid,
+	}
 }
 
 // Folder represents a folder with files and subfolders.
@@ -23,10 +49,17 @@ type Folder struct {
 	Name           string
 	Path           string
 	Parent         *Folder
-	Folders        sync.Map
-	files          sync.Map
+	Folders        storeMap
+	files          storeMap
 	fileCount      int32
 	fileCountCache int32
+	fileSize       int64
+	fileSizeCache  int64
+	// loader fills the files of a lazily loaded folder on first access.
+	loader   func(f *Folder)
+	loadOnce sync.Once
+	// Info is the metadata recorded by the scanner, nil for folders only known by their files.
+	Info *FolderInfo
 }
 
 // MatchedFileGroup represents a group of files with the same hash.
@@ -37,6 +70,7 @@ type MatchedFileGroup struct {
 
 // GetFiles returns all files in this folder.
 func (f *Folder) GetFiles() []*File {
+	f.load()
 	files := []*File{}
 	f.files.Range(func(key, value interface{}) bool {
 		files = append(files, value.(*File))
@@ -50,6 +84,7 @@ func (f *Folder) AddFile(file *File) error {
 	f.files.Store(file.Name, file)
 	file.Parent = f
 	atomic.AddInt32(&f.fileCount, 1)
+	atomic.AddInt64(&f.fileSize, file.Size)
 	f.invalidateCache()
 	return nil
 }
@@ -59,6 +94,7 @@ func (f *Folder) RemoveFile(file *File) error {
 	f.files.Delete(file.Name)
 	file.Parent = nil
 	atomic.AddInt32(&f.fileCount, -1)
+	atomic.AddInt64(&f.fileSize, -file.Size)
 	f.invalidateCache()
 	return nil
 }
@@ -80,6 +116,22 @@ func (f *Folder) GetFileCount() int {
 	return c
 }
 
+// GetFileSize returns the total size of the files in this folder and subfolders.
+func (f *Folder) GetFileSize() int64 {
+	cached := atomic.LoadInt64(&f.fileSizeCache)
+	if cached != 0 {
+		return cached
+	}
+
+	size := atomic.LoadInt64(&f.fileSize)
+	f.Folders.Range(func(key, value interface{}) bool {
+		size += value.(*Folder).GetFileSize()
+		return true
+	})
+	atomic.StoreInt64(&f.fileSizeCache, size)
+	return size
+}
+
 // GetFolders returns all subfolders of this folder.
 func (f *Folder) GetFolders() []*Folder {
 	folders := []*Folder{}
@@ -91,9 +143,19 @@ func (f *Folder) GetFolders() []*Folder {
 	return folders
 }
 
-// invalidateCache clears the file count cache for this folder and its parents.
+// load runs the lazy loader of this folder once, if any.
+func (f *Folder) load() {
+	if f.loader != nil {
+		f.loadOnce.Do(func() {
+			f.loader(f)
+		})
+	}
+}
+
+// invalidateCache clears the file count and size caches for this folder and its parents.
 func (f *Folder) invalidateCache() {
 	atomic.StoreInt32(&f.fileCountCache, 0)
+	atomic.StoreInt64(&f.fileSizeCache, 0)
 	if f.Parent != nil {
 		f.Parent.invalidateCache()
 	}
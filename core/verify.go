@@ -0,0 +1,213 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+
+	"github.com/kalafut/imohash"
+)
+
+// VerifyResult lists the differences between storage and the file system.
+type VerifyResult struct {
+	// Missing files are stored but no longer exist.
+	Missing []*File
+	// Changed files exist with a different size, modification time or hash.
+	// Old is the stored file, New describes the file found on disk.
+	Changed []FileChange
+	// Untracked files exist on disk but are not stored.
+	Untracked []*File
+}
+
+// Verifier compares the files of a storage with the file system under Path.
+type Verifier struct {
+	Path    string
+	Storage Storage
+	Logger  func(message string)
+	Context context.Context
+	// Hasher selects the hashing mode used by Rehash, HasherImohash when empty.
+	Hasher string
+	// Rehash hashes every file with unchanged size and modification time to detect changed content.
+	Rehash bool
+}
+
+// Verify stats every stored file and walks the file system for untracked files.
+// Hidden files are skipped like the scanner does.
+func (v *Verifier) Verify() (*VerifyResult, error) {
+	if v.Context == nil {
+		v.Context = context.Background()
+	}
+	switch v.Hasher {
+	case "":
+		v.Hasher = HasherImohash
+	case HasherImohash, HasherAudio, HasherSHA256, HasherMD5:
+	default:
+		return nil, fmt.Errorf("unknown hasher %s", v.Hasher)
+	}
+
+	root, err := os.OpenRoot(v.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open root directory %s: %w", v.Path, err)
+	}
+	defer root.Close()
+
+	result := &VerifyResult{
+		Missing:   []*File{},
+		Changed:   []FileChange{},
+		Untracked: []*File{},
+	}
+	hasher := imohash.New()
+
+	stored := map[string]bool{}
+	for file := range v.Storage.Walk(".") {
+		if err := v.Context.Err(); err != nil {
+			return nil, err
+		}
+		stored[file.Path] = true
+
+		info, err := root.Stat(file.Path)
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && !info.Mode().IsRegular()) {
+			result.Missing = append(result.Missing, file)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to stat file %s: %w", file.Path, err)
+		}
+
+		current := &File{
+			Name:    file.Name,
+			Path:    file.Path,
+			Hash:    file.Hash,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if current.Size != file.Size || !current.ModTime.Equal(file.ModTime) {
+			current.Hash = ""
+			result.Changed = append(result.Changed, FileChange{Old: file, New: current})
+			continue
+		}
+		if v.Rehash {
+			hash, err := v.hash(root, file.Path, hasher)
+			if err != nil {
+				return nil, err
+			}
+			if hash != file.Hash {
+				current.Hash = hash
+				result.Changed = append(result.Changed, FileChange{Old: file, New: current})
+			}
+		}
+		if v.Logger != nil {
+			v.Logger(fmt.Sprintf("verified file %s", file.Path))
+		}
+	}
+
+	err = fs.WalkDir(root.FS(), ".", func(path string, d fs.DirEntry, err error) error {
+		if err := v.Context.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name()[0] == '.' || stored[path] {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat file %s: %w", path, err)
+		}
+		result.Untracked = append(result.Untracked, &File{
+			Name:    d.Name(),
+			Path:    path,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory %s: %w", v.Path, err)
+	}
+
+	sortFilesByPath(result.Missing)
+	sortFilesByPath(result.Untracked)
+	sort.Slice(result.Changed, func(i, j int) bool {
+		return result.Changed[i].Old.Path < result.Changed[j].Old.Path
+	})
+	return result, nil
+}
+
+func (v *Verifier) hash(root *os.Root, path string, hasher imohash.ImoHash) (string, error) {
+	f, err := root.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	hash, _, err := hashFile(f, v.Hasher, hasher)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash file %s: %w", path, err)
+	}
+	return hash, nil
+}
+
+// Stale returns the number of stored files which are missing or changed.
+func (r *VerifyResult) Stale() int {
+	return len(r.Missing) + len(r.Changed)
+}
+
+// Prune removes the missing and changed files from storage, so no action is planned on them.
+func (r *VerifyResult) Prune(storage Storage) error {
+	errs := []error{}
+	for _, file := range r.Missing {
+		if err := storage.RemoveFile(file); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove file %s: %w", file.Path, err))
+		}
+	}
+	for _, change := range r.Changed {
+		if err := storage.RemoveFile(change.Old); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove file %s: %w", change.Old.Path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Summary returns the number of differences on a single line.
+func (r *VerifyResult) Summary() string {
+	return fmt.Sprintf("%d missing, %d changed, %d untracked files", len(r.Missing), len(r.Changed), len(r.Untracked))
+}
+
+// WriteText writes a human-readable list of the differences.
+func (r *VerifyResult) WriteText(w io.Writer) error {
+	lines := []string{}
+	lines = append(lines, fmt.Sprintf("Missing (%d):", len(r.Missing)))
+	for _, file := range r.Missing {
+		lines = append(lines, fmt.Sprintf("  - %s (%s)", file.Path, FormatFileSize(file.Size)))
+	}
+	lines = append(lines, fmt.Sprintf("Changed (%d):", len(r.Changed)))
+	for _, change := range r.Changed {
+		reason := "content changed"
+		if change.Old.Size != change.New.Size {
+			reason = fmt.Sprintf("%s -> %s", FormatFileSize(change.Old.Size), FormatFileSize(change.New.Size))
+			if change.Old.Size/1024 == change.New.Size/1024 {
+				reason = fmt.Sprintf("%d -> %d bytes", change.Old.Size, change.New.Size)
+			}
+		} else if !change.Old.ModTime.Equal(change.New.ModTime) {
+			reason = "modified " + change.New.ModTime.Format("2006-01-02 15:04:05")
+		}
+		lines = append(lines, fmt.Sprintf("  ~ %s (%s)", change.Old.Path, reason))
+	}
+	lines = append(lines, fmt.Sprintf("Untracked (%d):", len(r.Untracked)))
+	for _, file := range r.Untracked {
+		lines = append(lines, fmt.Sprintf("  + %s (%s)", file.Path, FormatFileSize(file.Size)))
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"folder-similarity/core"
+	"os"
+)
+
+// runDiff compares two saved databases and prints the changes.
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "print the diff as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dedup diff [-json] <old db> <new db>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("two database files are required")
+	}
+
+	oldStorage, newStorage := core.NewMemoryStorage(), core.NewMemoryStorage()
+	oldHeader, err := core.LoadDatabase(flags.Arg(0), oldStorage, "", "")
+	if err != nil {
+		return err
+	}
+	newHeader, err := core.LoadDatabase(flags.Arg(1), newStorage, "", "")
+	if err != nil {
+		return err
+	}
+	if oldHeader.Hasher != newHeader.Hasher {
+		return fmt.Errorf("%w: %s uses %s, %s uses %s", core.ErrHasherMismatch, flags.Arg(0), oldHeader.Hasher, flags.Arg(1), newHeader.Hasher)
+	}
+
+	diff := core.DiffStorage(oldStorage, newStorage)
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diff)
+	}
+	return diff.WriteText(os.Stdout)
+}
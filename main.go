@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"folder-similarity/core"
@@ -9,31 +8,184 @@ import (
 	logui "folder-similarity/ui/log"
 	"log"
 	"os"
+	"slices"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
 
 var rootPath string
 var dataPath string
+var hasher string
+var storageType string
+var dbPath string
+var savePath string
+var reportPrefix string
+var keyReportPrefix string
+var reportColumns string
+var reportDelimiter string
+var printStats bool
+var remapValue string
+var manifestPath string
+var metricName string
+var minFiles int
+var minSize string
+var minPercentage float64
+var bothSides bool
+var maxFanOut int
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "diff":
+			if err := runDiff(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "verify":
+			if err := runVerify(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "manifest":
+			if err := runManifest(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	flag.StringVar(&rootPath, "path", "", "root path")
-	flag.StringVar(&dataPath, "data", "", "load existing data from json file")
+	flag.StringVar(&dataPath, "data", "", "load existing data from json file (optionally gzip compressed), several label=file separated by commas are merged")
+	flag.StringVar(&remapValue, "remap", "", "relocate the root recorded in the database, given as old=new, or label:old=new separated by commas for merged databases")
+	flag.StringVar(&savePath, "save", "db.json.gz", "file written by the save key, gzip compressed when ending with .gz or replacing a compressed file")
+	flag.StringVar(&hasher, "hash", core.HasherImohash, "hash mode: imohash, audio (ignore MP3/FLAC tags), sha256 or md5 (full content)")
+	flag.StringVar(&manifestPath, "manifest", "", "sha256sum or md5sum file with the hashes of files under the root path, which are not read when their size is recorded")
+	flag.StringVar(&reportPrefix, "csv", "", "write <prefix>-groups.csv and <prefix>-pairs.csv reports and exit")
+	flag.StringVar(&keyReportPrefix, "report", "report", "prefix of the CSV reports written by the report key")
+	flag.StringVar(&reportColumns, "csv-columns", "", "comma separated list of report columns, all columns when empty")
+	flag.StringVar(&reportDelimiter, "csv-delim", ",", "report field delimiter, a single character or \"tab\"")
+	flag.BoolVar(&printStats, "stats", false, "print file and duplicate statistics and exit")
+	flag.StringVar(&storageType, "storage", "memory", "storage backend: memory, compact or disk")
+	flag.StringVar(&dbPath, "db", "dedup.db", "database file used by the disk storage")
+	flag.StringVar(&metricName, "metric", "count", "similarity metric: count (duplicate files), bytes (duplicate bytes) or both")
+	flag.IntVar(&minFiles, "min-files", 0, "report folder pairs sharing at least this many files")
+	flag.StringVar(&minSize, "min-size", "0", "report folder pairs sharing at least this many bytes, like 100MB")
+	flag.Float64Var(&minPercentage, "min-percent", 0, "report folder pairs similar by at least this percentage on either side")
+	flag.BoolVar(&bothSides, "both-sides", false, "require the minimum percentage on both sides of a folder pair")
+	flag.IntVar(&maxFanOut, "max-fan-out", core.DefaultMaxFanOut, "files held in more folders only count in folder pairs sharing other files, negative to pair every folder")
 	flag.Parse()
 
-	if rootPath == "" {
+	remaps, err := core.ParseRootRemaps(remapValue)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// several databases are merged below a folder for each
+	dataSpecs := strings.Split(dataPath, ",")
+	merged := len(dataSpecs) > 1 || strings.Contains(dataPath, "=")
+	if !merged && len(remaps) > 1 {
+		log.Fatal("several remaps need merged databases")
+	}
+	for _, remap := range remaps {
+		if !merged && remap.Label != "" {
+			log.Fatalf("the remap of %s needs merged databases", remap.Label)
+		}
+	}
+
+	if rootPath == "" && !merged {
 		rootPath = flag.Arg(0)
+		if rootPath == "" && len(remaps) > 0 {
+			rootPath = remaps[0].To
+		}
 		if rootPath == "" {
 			log.Fatal("root path is required")
 		}
 	}
 
-	storage := core.NewMemoryStorage()
+	reportOptions, err := parseReportOptions(reportColumns, reportDelimiter)
+	if err != nil {
+		log.Fatal(err)
+	}
+	metric, err := core.ParseSimilarityMetric(metricName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	threshold := core.SimilarityThreshold{
+		MinFiles:      minFiles,
+		MinPercentage: minPercentage,
+		BothSides:     bothSides,
+	}
+	threshold.MinSize, err = core.ParseFileSize(minSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var manifest *core.Manifest
+	if manifestPath != "" {
+		if dataPath != "" {
+			log.Fatal("-manifest is used when scanning and cannot be combined with -data")
+		}
+		manifest, err = core.LoadManifest(manifestPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hashSet := false
+		flag.Visit(func(f *flag.Flag) {
+			hashSet = hashSet || f.Name == "hash"
+		})
+		if !hashSet {
+			hasher = manifest.Hasher
+		}
+	}
+
+	var storage core.Storage
+	var header core.DatabaseHeader
+	loaded := false
+	switch storageType {
+	case "memory":
+		storage = core.NewMemoryStorage()
+	case "compact":
+		storage = core.NewCompactStorage()
+	case "disk":
+		diskStorage, err := core.OpenDiskStorage(dbPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer diskStorage.Close()
+		if count := diskStorage.FileCount(); count > 0 {
+			fmt.Printf("Opened %s with %d files\n", dbPath, count)
+			if diskHeader := diskStorage.Header(); diskHeader != nil {
+				header = *diskHeader
+				header.Roots = slices.Clone(diskHeader.Roots)
+				if err := header.Remap(remaps...); err != nil {
+					log.Fatal(err)
+				}
+				if err := header.Check(rootPath, hasher); err != nil {
+					log.Fatal(err)
+				}
+				if err := header.VerifyFingerprint(rootPath); err != nil {
+					log.Fatal(err)
+				}
+				if len(remaps) > 0 {
+					if err := diskStorage.SetHeader(header); err != nil {
+						log.Fatal(err)
+					}
+				}
+			}
+			loaded = true
+		}
+		storage = diskStorage
+	default:
+		log.Fatalf("unknown storage %s", storageType)
+	}
 	logChan := make(chan string)
 
 	scanner := core.Scanner{
-		Storage: storage,
-		Path:    []string{rootPath},
+		Storage:  storage,
+		Path:     []string{rootPath},
+		Hasher:   hasher,
+		Manifest: manifest,
 		Logger: func(message string) {
 			logChan <- message
 		},
@@ -49,28 +201,85 @@ func main() {
 		}
 	}()
 
-	if dataPath != "" {
-		fmt.Println("Loading existing data from", dataPath)
-		jsonData, err := os.ReadFile(dataPath)
+	var mounts []core.Mount
+	if merged {
+		if loaded {
+			log.Fatalf("cannot merge databases into %s which already holds files", dbPath)
+		}
+		for _, spec := range dataSpecs {
+			fmt.Println("Loading existing data from", spec)
+		}
+		mounts, err = core.MountDatabases(storage, dataSpecs, hasher, remaps...)
 		if err != nil {
 			log.Fatal(err)
 		}
-		var files []*core.File
-		err = json.Unmarshal(jsonData, &files)
+		header = core.DatabaseHeader{Hasher: mounts[0].Header.Hasher}
+		for _, mount := range mounts {
+			if !mount.Online {
+				fmt.Printf("%s is offline, actions on it are disabled\n", mount.Label)
+			}
+		}
+	} else if dataPath != "" {
+		if loaded {
+			log.Fatalf("cannot load %s into %s which already holds files", dataPath, dbPath)
+		}
+		fmt.Println("Loading existing data from", dataPath)
+		dataHeader, err := core.LoadDatabase(dataPath, storage, rootPath, hasher, remaps...)
 		if err != nil {
 			log.Fatal(err)
 		}
-		for _, file := range files {
-			storage.AddFile(file)
+		if err := dataHeader.VerifyFingerprint(rootPath); err != nil {
+			log.Fatal(err)
 		}
-	} else {
+		header = *dataHeader
+	} else if !loaded {
 		err := scanner.Scan()
 		if err != nil {
 			log.Fatal(err)
 		}
+		header = scanner.Header()
+		if diskStorage, ok := storage.(*core.DiskStorage); ok {
+			header.Fingerprint = core.NewFingerprint(storage)
+			if err := diskStorage.SetHeader(header); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if compactStorage, ok := storage.(*core.CompactStorage); ok {
+		compactStorage.Freeze()
+	}
+	if len(header.Roots) == 0 && !merged {
+		// legacy data without a recorded root
+		header.Roots = scanner.Header().Roots
 	}
 	close(logChan)
 
+	// Print the statistics instead of starting the UI
+	if printStats {
+		stats, err := core.CalculateStats(storage)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := stats.WriteText(os.Stdout, 0); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Write the CSV report instead of starting the UI
+	if reportPrefix != "" {
+		similarityChecker := &core.SimilarityChecker{Metric: metric, Threshold: threshold, MaxFanOut: maxFanOut}
+		if err := similarityChecker.CalculateSimilarity(storage); err != nil {
+			log.Fatal(err)
+		}
+		paths, err := core.WriteReport(reportPrefix, storage, similarityChecker, reportOptions)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Report written to", strings.Join(paths, ", "))
+		return
+	}
+
 	// Initialize the main model
 	m := ui.NewMainModel()
 	p := tea.NewProgram(m, tea.WithAltScreen())
@@ -81,15 +290,23 @@ func main() {
 	// Initialize storage and scan folder
 	m.SetStorage(storage)
 	m.SetRootPath(rootPath)
+	m.SetDatabaseHeader(header)
+	m.SetSavePath(savePath)
+	m.SetReportPrefix(keyReportPrefix)
+	m.SetReportOptions(reportOptions)
+	m.SetMounts(mounts)
 	// err := core.ScanFolder(context.Background(), m.GetRootPath(), m.GetStorage())
 	// if err != nil {
 	// 	log.Fatal(err)
 	// }
 
 	// Initialize similarity checker
-	similarityChecker := &core.SimilarityChecker{}
-	similarityChecker.CalculateSimilarity(m.GetStorage())
+	similarityChecker := &core.SimilarityChecker{Threshold: threshold, MaxFanOut: maxFanOut}
+	if err := similarityChecker.CalculateSimilarity(m.GetStorage()); err != nil {
+		log.Fatal(err)
+	}
 	m.SetSimilarityChecker(similarityChecker)
+	m.SetMetric(metric)
 
 	// Set up root folder
 	root, err := m.GetStorage().GetFolder(".")
@@ -103,3 +320,26 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// parseReportOptions builds the CSV report options from the command line flags.
+func parseReportOptions(columns string, delimiter string) (core.ReportOptions, error) {
+	options := core.ReportOptions{}
+	if columns != "" {
+		for _, column := range strings.Split(columns, ",") {
+			options.Columns = append(options.Columns, strings.TrimSpace(column))
+		}
+	}
+
+	switch delimiter {
+	case "tab", "\\t":
+		options.Delimiter = '\t'
+	default:
+		runes := []rune(delimiter)
+		if len(runes) != 1 {
+			return options, fmt.Errorf("invalid report delimiter %q", delimiter)
+		}
+		options.Delimiter = runes[0]
+	}
+
+	return options, options.Validate()
+}
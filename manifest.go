@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"folder-similarity/core"
+	"io"
+	"os"
+)
+
+// runManifest exports a saved database as a sha256sum or md5sum compatible checksum file.
+func runManifest(args []string) error {
+	flags := flag.NewFlagSet("manifest", flag.ExitOnError)
+	output := flags.String("o", "", "file to write, standard output when empty")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dedup manifest [-o file] <db>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("a database file is required")
+	}
+
+	storage := core.NewMemoryStorage()
+	header, err := core.LoadDatabase(flags.Arg(0), storage, "", "")
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := core.WriteManifest(w, storage, header.Hasher); err != nil {
+		return err
+	}
+	if len(header.Roots) > 0 {
+		fmt.Fprintf(os.Stderr, "Paths are relative to %s\n", header.Roots[0])
+	}
+	return nil
+}
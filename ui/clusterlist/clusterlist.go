@@ -0,0 +1,224 @@
+package clusterlist
+
+import (
+	"fmt"
+	"folder-similarity/core"
+	"strconv"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/table"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	TitleStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("229")).
+			Background(lipgloss.Color("129"))
+
+	KeepIcon   = "✓"
+	MergeIcon  = "→"
+	DeleteIcon = "⌫"
+)
+
+// Model lists the clusters of similar folders, one row per member,
+// to keep one folder of a cluster and merge or delete the others.
+type Model struct {
+	ready    bool
+	clusters []*core.FolderCluster
+	metric   core.SimilarityMetric
+	// keep is the index of the member to keep in every cluster
+	keep []int
+	// merge moves the files missing in the kept folder into it instead of deleting them
+	merge bool
+	// rows maps every table row to its cluster and member index
+	rows   [][2]int
+	table  table.Model
+	help   help.Model
+	width  int
+	height int
+	keyMap KeyMap
+}
+
+type KeyMap struct {
+	Keep  key.Binding
+	Merge key.Binding
+	Apply key.Binding
+	Close key.Binding
+}
+
+func (k KeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{
+		k.Keep,
+		k.Merge,
+		k.Apply,
+		k.Close,
+	}
+}
+
+func (k KeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Keep, k.Merge, k.Apply, k.Close},
+	}
+}
+
+func DefaultKeyMap() KeyMap {
+	return KeyMap{
+		Keep: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "keep this folder"),
+		),
+		Merge: key.NewBinding(
+			key.WithKeys("m"),
+			key.WithHelp("m", "merge/delete unique files"),
+		),
+		Apply: key.NewBinding(
+			key.WithKeys("A"),
+			key.WithHelp("A", "apply to cluster"),
+		),
+		Close: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "close"),
+		),
+	}
+}
+
+// ApplyMsg is sent to keep a member of the cluster and merge or delete the others.
+type ApplyMsg struct {
+	Cluster *core.FolderCluster
+	Keeper  int
+	Merge   bool
+}
+
+// CloseMsg is sent when the list is closed without applying.
+type CloseMsg struct{}
+
+func (m Model) Init() tea.Cmd {
+	return nil
+}
+
+// SetClusters shows the clusters with the coverage of their members measured by metric,
+// keeping the first member of every cluster.
+func (m *Model) SetClusters(clusters []*core.FolderCluster, metric core.SimilarityMetric) {
+	m.clusters = clusters
+	m.metric = metric
+	m.keep = make([]int, len(clusters))
+	m.rows = nil
+	for i, cluster := range clusters {
+		for j := range cluster.Members {
+			m.rows = append(m.rows, [2]int{i, j})
+		}
+	}
+	m.table.SetCursor(min(m.table.Cursor(), max(0, len(m.rows)-1)))
+	m.updateItems()
+}
+
+func (m *Model) updateItems() {
+	rows := []table.Row{}
+	for _, index := range m.rows {
+		member := m.clusters[index[0]].Members[index[1]]
+		number, icon := "", DeleteIcon
+		if index[1] == 0 {
+			number = strconv.Itoa(index[0] + 1)
+		}
+		if m.keep[index[0]] == index[1] {
+			icon = KeepIcon
+		} else if m.merge {
+			icon = MergeIcon
+		}
+		rows = append(rows, table.Row{
+			number,
+			icon,
+			member.Path,
+			core.FormatFileSize(member.TotalSize),
+			member.Coverage(m.metric),
+		})
+	}
+	m.table.SetRows(rows)
+}
+
+func (m *Model) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	m.table.SetWidth(width)
+	m.table.SetHeight(height)
+	m.ready = true
+
+	columns := m.table.Columns()
+	columns[2].Width = max(15, width-50)
+	m.table.SetColumns(columns)
+}
+
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	m.table, _ = m.table.Update(msg)
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keyMap.Close):
+			return &m, func() tea.Msg { return CloseMsg{} }
+		case key.Matches(msg, m.keyMap.Merge):
+			m.merge = !m.merge
+		case len(m.rows) == 0:
+			return &m, nil
+		case key.Matches(msg, m.keyMap.Keep):
+			index := m.rows[m.table.Cursor()]
+			m.keep[index[0]] = index[1]
+		case key.Matches(msg, m.keyMap.Apply):
+			index := m.rows[m.table.Cursor()]
+			applyMsg := ApplyMsg{Cluster: m.clusters[index[0]], Keeper: m.keep[index[0]], Merge: m.merge}
+			return &m, func() tea.Msg { return applyMsg }
+		}
+
+		m.updateItems()
+	}
+	return &m, nil
+}
+
+func (m Model) View() string {
+	if !m.ready || m.width == 0 || m.height == 0 {
+		return "Loading..."
+	}
+
+	helpView := m.help.View(m.keyMap)
+	action := "delete"
+	if m.merge {
+		action = "merge into the kept folder"
+	}
+	title := TitleStyle.Width(m.width).Render(fmt.Sprintf("%d folder clusters (%s the others)", len(m.clusters), action))
+
+	m.table.SetHeight(m.height - lipgloss.Height(title) - lipgloss.Height(helpView))
+	return lipgloss.JoinVertical(lipgloss.Left, title, m.table.View(), helpView)
+}
+
+func New() *Model {
+	columns := []table.Column{
+		{Title: "No.", Width: 4},
+		{Title: "A", Width: 1},
+		{Title: "Folder", Width: 15},
+		{Title: "Size", Width: 10},
+		{Title: "Coverage", Width: 24},
+	}
+
+	s := table.DefaultStyles()
+	s.Header = s.Header.
+		BorderStyle(lipgloss.NormalBorder()).
+		BorderForeground(lipgloss.Color("240")).
+		BorderBottom(true).
+		Bold(false)
+	s.Selected = s.Selected.
+		Foreground(lipgloss.Color("229")).
+		Background(lipgloss.Color("57")).
+		Bold(false)
+
+	return &Model{
+		keyMap: DefaultKeyMap(),
+		table: table.New(
+			table.WithColumns(columns),
+			table.WithFocused(true),
+			table.WithStyles(s),
+		),
+		help: help.New(),
+	}
+}
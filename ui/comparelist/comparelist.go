@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"folder-similarity/core"
 	"strconv"
+	"strings"
 
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
@@ -19,6 +20,8 @@ var (
 	FolderBPathStyle = lipgloss.NewStyle().
 				Foreground(lipgloss.Color("229")).
 				Background(lipgloss.Color("64"))
+	TagDiffStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("214"))
 
 	ActionIcons = []string{"", "⌦", "⌫", "⏵", "⏴"}
 )
@@ -34,6 +37,7 @@ type Model struct {
 	keyMap      KeyMap
 	filePairs   []core.MergeFilePair
 	folderPairs []core.MergeFolderPair
+	metric      core.SimilarityMetric
 }
 
 type KeyMap struct {
@@ -146,6 +150,11 @@ func (m *Model) SetMergeFolderPair(mergeFolderPair *core.MergeFolderPair) {
 
 }
 
+// SetMetric sets the similarity metric shown in the folder headers
+func (m *Model) SetMetric(metric core.SimilarityMetric) {
+	m.metric = metric
+}
+
 func (m *Model) updateItems() {
 	if len(m.filePairs) == 0 && len(m.folderPairs) == 0 {
 		m.table.SetRows([]table.Row{})
@@ -276,13 +285,38 @@ func (m Model) View() string {
 	if m.folder1 != nil && m.folder2 != nil {
 		pathInfo = lipgloss.JoinHorizontal(
 			lipgloss.Top,
-			FolderAPathStyle.Width(m.width/2).Render(m.folder1.Path+fmt.Sprintf(" (cover %.02f%% - %d/%d)", m.folder1.DuplicatedPercentage(), m.folder1.DuplicateFileCount, m.folder1.FileCount)),
-			FolderBPathStyle.Width(m.width/2).Render(m.folder2.Path+fmt.Sprintf(" (cover %.02f%% - %d/%d)", m.folder2.DuplicatedPercentage(), m.folder2.DuplicateFileCount, m.folder2.FileCount)),
+			FolderAPathStyle.Width(m.width/2).Render(m.folder1.Path+fmt.Sprintf(" (cover %s)", m.folder1.Coverage(m.metric))),
+			FolderBPathStyle.Width(m.width/2).Render(m.folder2.Path+fmt.Sprintf(" (cover %s)", m.folder2.Coverage(m.metric))),
 		)
 	}
-	m.table.SetHeight(m.height - lipgloss.Height(pathInfo) - lipgloss.Height(helpView))
+	tagInfo := m.tagDiffView()
+	if tagInfo == "" {
+		m.table.SetHeight(m.height - lipgloss.Height(pathInfo) - lipgloss.Height(helpView))
+		return lipgloss.JoinVertical(lipgloss.Left, pathInfo, m.table.View(), helpView)
+	}
+	m.table.SetHeight(m.height - lipgloss.Height(pathInfo) - lipgloss.Height(tagInfo) - lipgloss.Height(helpView))
+
+	return lipgloss.JoinVertical(lipgloss.Left, pathInfo, m.table.View(), tagInfo, helpView)
+}
+
+// tagDiffView renders the tag differences of the highlighted file pair
+// when both files contain the same audio with different tags.
+func (m Model) tagDiffView() string {
+	index := m.table.Cursor() - len(m.folderPairs)
+	if index < 0 || index >= len(m.filePairs) {
+		return ""
+	}
 
-	return lipgloss.JoinVertical(lipgloss.Left, pathInfo, m.table.View(), helpView)
+	diff := m.filePairs[index].GetTagDiff()
+	if len(diff) == 0 {
+		return ""
+	}
+
+	parts := []string{}
+	for _, d := range diff {
+		parts = append(parts, fmt.Sprintf("%s: %q ⇄ %q", d.Name, d.Value1, d.Value2))
+	}
+	return TagDiffStyle.Width(m.width).Render("♪ same audio, different tags - " + strings.Join(parts, ", "))
 }
 
 func (m *Model) GetActions() []core.FileActionTask {
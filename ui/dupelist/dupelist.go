@@ -0,0 +1,244 @@
+package dupelist
+
+import (
+	"fmt"
+	"folder-similarity/core"
+	"folder-similarity/ui/comparelist"
+	"strconv"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/table"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	FolderPathStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("229")).
+			Background(lipgloss.Color("129"))
+
+	KeepIcon    = "✓"
+	DeleteIcon  = "⌫"
+	TagDiffIcon = "♪"
+)
+
+// Model lists the groups of duplicate files inside a folder, one row per file,
+// and deletes every file of a group except the one to keep.
+type Model struct {
+	ready  bool
+	path   string
+	groups []*core.MatchedFileGroup
+	// keep is the index of the file to keep in every group
+	keep []int
+	// tagDiffs marks the groups of the same audio with different tags
+	tagDiffs []bool
+	// rows maps every table row to its group and file index
+	rows   [][2]int
+	table  table.Model
+	help   help.Model
+	width  int
+	height int
+	keyMap KeyMap
+}
+
+type KeyMap struct {
+	Keep  key.Binding
+	Apply key.Binding
+	Close key.Binding
+}
+
+func (k KeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{
+		k.Keep,
+		k.Apply,
+		k.Close,
+	}
+}
+
+func (k KeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Keep, k.Apply, k.Close},
+	}
+}
+
+func DefaultKeyMap() KeyMap {
+	return KeyMap{
+		Keep: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "keep this copy"),
+		),
+		Apply: key.NewBinding(
+			key.WithKeys("A"),
+			key.WithHelp("A", "delete other copies"),
+		),
+		Close: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "close"),
+		),
+	}
+}
+
+// CloseMsg is sent when the list is closed without applying.
+type CloseMsg struct{}
+
+func (m Model) Init() tea.Cmd {
+	return nil
+}
+
+// SetGroups shows the duplicate groups of the folder at path, keeping the first file of every group.
+func (m *Model) SetGroups(path string, groups []*core.MatchedFileGroup) {
+	m.path = path
+	m.groups = groups
+	m.keep = make([]int, len(groups))
+	m.tagDiffs = make([]bool, len(groups))
+	m.rows = nil
+	for i, group := range groups {
+		m.tagDiffs[i] = group.HasTagDifferences()
+		for j := range group.Files {
+			m.rows = append(m.rows, [2]int{i, j})
+		}
+	}
+	m.table.SetCursor(0)
+	m.updateItems()
+}
+
+// GetPath returns the path of the folder shown.
+func (m *Model) GetPath() string {
+	return m.path
+}
+
+func (m *Model) updateItems() {
+	rows := []table.Row{}
+	for _, index := range m.rows {
+		file := m.groups[index[0]].Files[index[1]]
+		number, icon := "", DeleteIcon
+		if index[1] == 0 {
+			number = strconv.Itoa(index[0] + 1)
+			if m.tagDiffs[index[0]] {
+				number += TagDiffIcon
+			}
+		}
+		if m.keep[index[0]] == index[1] {
+			icon = KeepIcon
+		}
+		rows = append(rows, table.Row{
+			number,
+			icon,
+			file.Name,
+			core.FormatFileSize(file.Size),
+			file.ModTime.Format("2006-01-02 15:04"),
+		})
+	}
+	m.table.SetRows(rows)
+}
+
+func (m *Model) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	m.table.SetWidth(width)
+	m.table.SetHeight(height)
+	m.ready = true
+
+	columns := m.table.Columns()
+	columns[2].Width = max(15, width-43)
+	m.table.SetColumns(columns)
+}
+
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	m.table, _ = m.table.Update(msg)
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keyMap.Close):
+			return &m, func() tea.Msg { return CloseMsg{} }
+		case len(m.rows) == 0:
+			return &m, nil
+		case key.Matches(msg, m.keyMap.Keep):
+			index := m.rows[m.table.Cursor()]
+			m.keep[index[0]] = index[1]
+		case key.Matches(msg, m.keyMap.Apply):
+			actions := m.GetActions()
+			return &m, func() tea.Msg {
+				return comparelist.ActionApplyMsg{Actions: actions}
+			}
+		}
+
+		m.updateItems()
+	}
+	return &m, nil
+}
+
+func (m Model) View() string {
+	if !m.ready || m.width == 0 || m.height == 0 {
+		return "Loading..."
+	}
+
+	helpView := m.help.View(m.keyMap)
+	wasted := int64(0)
+	count := 0
+	tagDiffs := 0
+	for i, group := range m.groups {
+		count += max(0, len(group.DistinctCopies())-1)
+		wasted += group.WastedSize()
+		if m.tagDiffs[i] {
+			tagDiffs++
+		}
+	}
+	info := fmt.Sprintf("%s (%d duplicate groups, %d extra copies - %s", m.path, len(m.groups), count, core.FormatFileSize(wasted))
+	if tagDiffs > 0 {
+		info += fmt.Sprintf(", %s %d with different tags", TagDiffIcon, tagDiffs)
+	}
+	pathInfo := FolderPathStyle.Width(m.width).Render(info + ")")
+
+	m.table.SetHeight(m.height - lipgloss.Height(pathInfo) - lipgloss.Height(helpView))
+	return lipgloss.JoinVertical(lipgloss.Left, pathInfo, m.table.View(), helpView)
+}
+
+// GetActions returns a task deleting every file of each group except the one to keep.
+func (m *Model) GetActions() []core.FileActionTask {
+	actions := []core.FileActionTask{}
+	for i, group := range m.groups {
+		for j, file := range group.Files {
+			if j != m.keep[i] {
+				actions = append(actions, core.FileActionTask{
+					Action: core.Delete,
+					File:   file,
+				})
+			}
+		}
+	}
+	return actions
+}
+
+func New() *Model {
+	columns := []table.Column{
+		{Title: "No.", Width: 4},
+		{Title: "A", Width: 1},
+		{Title: "Name", Width: 15},
+		{Title: "Size", Width: 8},
+		{Title: "Modified", Width: 16},
+	}
+
+	s := table.DefaultStyles()
+	s.Header = s.Header.
+		BorderStyle(lipgloss.NormalBorder()).
+		BorderForeground(lipgloss.Color("240")).
+		BorderBottom(true).
+		Bold(false)
+	s.Selected = s.Selected.
+		Foreground(lipgloss.Color("229")).
+		Background(lipgloss.Color("57")).
+		Bold(false)
+
+	return &Model{
+		keyMap: DefaultKeyMap(),
+		table: table.New(
+			table.WithColumns(columns),
+			table.WithFocused(true),
+			table.WithStyles(s),
+		),
+		help: help.New(),
+	}
+}
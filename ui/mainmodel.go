@@ -4,16 +4,19 @@ import (
 	"context"
 	"fmt"
 	"folder-similarity/core"
+	"folder-similarity/ui/clusterlist"
 	"folder-similarity/ui/comparelist"
 	"folder-similarity/ui/dialog"
+	"folder-similarity/ui/dupelist"
 	logui "folder-similarity/ui/log"
+	"folder-similarity/ui/pairlist"
 	"folder-similarity/ui/progress"
 	"folder-similarity/ui/selectlistdialog"
 	"folder-similarity/ui/tree"
-	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -22,6 +25,12 @@ import (
 
 type FocusState int
 
+// maxRankedPairs limits the folder pairs listed by the ranked pairs view
+const maxRankedPairs = 1000
+
+// minClusterPercentage is the lowest similarity on both sides for folders joined in a cluster
+const minClusterPercentage = 50
+
 const (
 	TreeFocus FocusState = iota
 	ListFocus
@@ -31,6 +40,16 @@ const (
 	ProgressFocus         = 100
 )
 
+// RightView is the view shown on the right side, above the log view
+type RightView int
+
+const (
+	CompareView RightView = iota
+	SameFolderView
+	RankedPairsView
+	ClusterView
+)
+
 type MainModel struct {
 	treeView     tree.Model
 	fileListView *comparelist.Model
@@ -42,6 +61,11 @@ type MainModel struct {
 	rootPath     string
 
 	storage           core.Storage
+	databaseHeader    core.DatabaseHeader
+	savePath          string
+	reportPrefix      string
+	reportOptions     core.ReportOptions
+	mounts            []core.Mount
 	similarityChecker *core.SimilarityChecker
 	rootFolder        *FolderItemWrapper
 	selectedFolder    *FolderItemWrapper
@@ -49,6 +73,11 @@ type MainModel struct {
 	actionConfirmDialog *dialog.Model
 	progressDialog      *progress.Model
 	selectListDialog    *selectlistdialog.Model
+	sameFolderView      *dupelist.Model
+	rankedPairsView     *pairlist.Model
+	clusterView         *clusterlist.Model
+	rightView           RightView
+	redundantFilter     bool
 	overlay             tea.Model
 	pendingActions      []core.FileActionTask
 	logger              core.Logger
@@ -115,6 +144,9 @@ func (m *MainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.treeView.Width = treeWidth
 		m.treeView.Height = treeHeight
 		m.fileListView.SetSize(rightWidth, fileListHeight)
+		m.sameFolderView.SetSize(rightWidth, fileListHeight)
+		m.rankedPairsView.SetSize(rightWidth, fileListHeight)
+		m.clusterView.SetSize(rightWidth, fileListHeight)
 		m.progressDialog.SetSize(rightWidth*3/4, 8)
 		m.actionConfirmDialog.SetSize(rightWidth*3/4, 8)
 		m.selectListDialog.SetSize(rightWidth*3/4, min(15, m.height-4))
@@ -133,13 +165,14 @@ func (m *MainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			rightWidth := m.width/4*3 - 2
 			dialogWidth := int(float64(rightWidth) * 0.75)
 			m.progressDialog.SetDialogWidth(dialogWidth)
-			m.overlay = overlay.New(m.progressDialog, m.fileListView, overlay.Center, overlay.Center, 0, 0)
+			m.overlay = overlay.New(m.progressDialog, m.mainView(), overlay.Center, overlay.Center, 0, 0)
 
 			// Create cancellable context
 			ctx, cancel := context.WithCancel(context.Background())
 			m.executorCancel = cancel
 
 			executor := core.NewExecutor(m.storage, m.rootPath, m.pendingActions, m.logger)
+			executor.SetMounts(m.mounts)
 			m.currentExecutor = executor
 
 			go func() {
@@ -229,7 +262,24 @@ func (m *MainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.executorCancel()
 		}
 		m.focus = TreeFocus
-		m.overlay = overlay.New(m.actionConfirmDialog, m.fileListView, overlay.Center, overlay.Center, 0, 0)
+		m.overlay = overlay.New(m.actionConfirmDialog, m.mainView(), overlay.Center, overlay.Center, 0, 0)
+		return m, nil
+	case dupelist.CloseMsg, pairlist.CloseMsg, clusterlist.CloseMsg:
+		m.rightView = CompareView
+		m.focus = TreeFocus
+		return m, nil
+	case pairlist.RankMsg:
+		m.ShowRankedPairs(msg.Ranking)
+		return m, nil
+	case pairlist.SelectMsg:
+		m.rightView = CompareView
+		m.mergeFolderPair = m.similarityChecker.GenerateMergeFolderPair(msg.Pair[0], msg.Pair[1])
+		m.fileListView.SetMergeFolderPair(&m.mergeFolderPair)
+		m.focus = ListFocus
+		return m, nil
+	case clusterlist.ApplyMsg:
+		actions := m.similarityChecker.GenerateClusterPlan(msg.Cluster, msg.Keeper, msg.Merge)
+		m.HandleApplyActions(comparelist.ActionApplyMsg{Actions: actions})
 		return m, nil
 	case comparelist.ActionApplyMsg: // Handle apply actions
 		m.HandleApplyActions(msg)
@@ -252,6 +302,7 @@ func (m *MainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			switch msg.String() {
 			// Filter tree view
 			case "f":
+				m.redundantFilter = false
 				highlightedItem := m.treeView.HighLightedItem()
 				if m.treeView.HasFilter() {
 					m.treeView.SetFilter(nil)
@@ -264,10 +315,83 @@ func (m *MainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.treeView.MoveToItem(highlightedItem)
 				}
 
+				// Filter redundant folders, with a copy of every file elsewhere
+			case "F":
+				m.redundantFilter = !m.redundantFilter
+				if m.redundantFilter {
+					m.treeView.SetFilter(m.RedundantFilter())
+				} else {
+					m.treeView.SetFilter(nil)
+				}
+				highlightedItem := m.treeView.HighLightedItem()
+				m.treeView.SetItems([]tree.Item{m.rootFolder})
+				if highlightedItem != nil {
+					m.treeView.MoveToItem(highlightedItem)
+				}
+
 				// Select folder
 			case "enter":
 				m.HandleTreeFolderSelected(m.treeView.Selected())
+
+				// Write CSV report
+			case "r":
+				paths, err := core.WriteReport(m.reportPrefix, m.storage, m.similarityChecker, m.reportOptions)
+				if err != nil {
+					m.logView.Error(err.Error())
+				} else {
+					m.logView.Info("Report written to " + strings.Join(paths, ", "))
+				}
+
+				// Show statistics
+			case "i":
+				m.ShowStats()
+
+				// Verify files, prune stale files with shift
+			case "v", "V":
+				m.VerifyFiles(msg.String() == "V")
+
+				// Switch the similarity metric between count, bytes and both
+			case "m":
+				m.SetMetric((m.similarityChecker.Metric + 1) % 3)
+				m.logView.Info("Similarity metric: " + m.similarityChecker.Metric.String())
+				m.reloadFilteredTree()
+
+				// Adjust the thresholds of the reported folder pairs
+			case "+", "-", "]", "[", "}", "{", "b":
+				m.AdjustThreshold(msg.String())
+
+				// List the most redundant folder pairs
+			case "p":
+				m.ShowRankedPairs(core.RankByReclaimable)
+
+				// List the clusters of similar folders
+			case "c":
+				m.ShowClusters()
+
+				// Show duplicates inside the highlighted folder
+			case "d":
+				if folder, ok := m.treeView.HighLightedItem().(*FolderItemWrapper); ok {
+					m.ShowSameFolderDuplicates(folder.Path)
+				}
+			}
+		} else if m.focus == ListFocus && m.rightView == SameFolderView {
+			l, cmd := m.sameFolderView.Update(msg)
+			if sameFolderView, ok := l.(*dupelist.Model); ok {
+				m.sameFolderView = sameFolderView
+			}
+			return m, cmd
+		} else if m.focus == ListFocus && m.rightView == RankedPairsView {
+			l, cmd := m.rankedPairsView.Update(msg)
+			if rankedPairsView, ok := l.(*pairlist.Model); ok {
+				m.rankedPairsView = rankedPairsView
 			}
+			return m, cmd
+		} else if m.focus == ListFocus && m.rightView == ClusterView {
+			l, cmd := m.clusterView.Update(msg)
+			if clusterView, ok := l.(*clusterlist.Model); ok {
+				m.clusterView = clusterView
+			}
+			return m, cmd
 		} else if m.focus == ListFocus {
 			l, cmd := m.fileListView.Update(msg)
 			if msg.String() == "o" {
@@ -283,18 +407,22 @@ func (m *MainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						m.OpenFileExplorer(folder2.Folder.Path)
 					}
 				}
+			} else if msg.String() == "s" && len(m.mounts) > 0 {
+				m.logView.Error("Saving merged databases is not supported")
 			} else if msg.String() == "s" {
 				switch m.storage.(type) {
-				case *core.MemoryStorage:
-					memoryStorage := m.storage.(*core.MemoryStorage)
-					jsonData, err := memoryStorage.ExportStorage()
+				case *core.MemoryStorage, *core.CompactStorage:
+					m.logView.Info("Save the file list to " + m.savePath)
+					err := core.SaveDatabase(m.savePath, m.storage, m.databaseHeader)
 					if err != nil {
 						m.logView.Error(err.Error())
 					}
-					m.logView.Info("Save the file list to db.json")
-					err = os.WriteFile("db.json", jsonData, 0644)
-					if err != nil {
+				case *core.DiskStorage:
+					diskStorage := m.storage.(*core.DiskStorage)
+					if err := diskStorage.Sync(); err != nil {
 						m.logView.Error(err.Error())
+					} else {
+						m.logView.Info("Database synced to disk")
 					}
 				}
 			}
@@ -343,6 +471,17 @@ func (m *MainModel) TreeFilter() func(item tree.Item) bool {
 	}
 }
 
+// RedundantFilter keeps the folders identical to or contained in another folder, and their parents
+func (m *MainModel) RedundantFilter() func(item tree.Item) bool {
+	return func(item tree.Item) bool {
+		folder, ok := item.(*FolderItemWrapper)
+		if !ok {
+			return false
+		}
+		return m.similarityChecker.ContainsRedundantFolder(folder.Path)
+	}
+}
+
 func (m *MainModel) View() string {
 	if !m.ready {
 		return "Loading..."
@@ -362,7 +501,7 @@ func (m *MainModel) View() string {
 	if m.focus == DialogFocus || m.focus == ProgressFocus || m.focus == SelectListDialogFocus {
 		mainContent = tableViewStyle.Render(m.overlay.View())
 	} else {
-		mainContent = tableViewStyle.Render(m.fileListView.View())
+		mainContent = tableViewStyle.Render(m.mainView().View())
 	}
 
 	// Create right side layout: FileListView on top, LogView on bottom
@@ -386,10 +525,15 @@ func NewMainModel() *MainModel {
 		fileListView: comparelist.New(),
 		logView:      logui.New(),
 		focus:        TreeFocus,
+		savePath:     "db.json.gz",
+		reportPrefix: "report",
 	}
 
 	m.actionConfirmDialog = dialog.New("", []string{"OK", "Cancel"})
 	m.progressDialog = progress.New()
+	m.sameFolderView = dupelist.New()
+	m.rankedPairsView = pairlist.New()
+	m.clusterView = clusterlist.New()
 	m.selectListDialog = selectlistdialog.New("Select folder pair to compare:", []string{}, false)
 
 	m.treeView.SetFilter(m.TreeFilter())
@@ -407,11 +551,42 @@ func (m *MainModel) SetSimilarityChecker(checker *core.SimilarityChecker) {
 	m.similarityChecker = checker
 }
 
+// SetMetric sets the similarity metric used to sort, show and filter the folder pairs
+func (m *MainModel) SetMetric(metric core.SimilarityMetric) {
+	m.similarityChecker.Metric = metric
+	m.fileListView.SetMetric(metric)
+}
+
 // SetRootPath sets the root path for the model
 func (m *MainModel) SetRootPath(path string) {
 	m.rootPath = path
 }
 
+// SetDatabaseHeader sets the header written when saving the database
+func (m *MainModel) SetDatabaseHeader(header core.DatabaseHeader) {
+	m.databaseHeader = header
+}
+
+// SetMounts sets the databases merged into the storage
+func (m *MainModel) SetMounts(mounts []core.Mount) {
+	m.mounts = mounts
+}
+
+// SetSavePath sets the file written when saving the database
+func (m *MainModel) SetSavePath(path string) {
+	m.savePath = path
+}
+
+// SetReportPrefix sets the prefix of the CSV report files written by the report key
+func (m *MainModel) SetReportPrefix(prefix string) {
+	m.reportPrefix = prefix
+}
+
+// SetReportOptions sets the options of the CSV report written by the report key
+func (m *MainModel) SetReportOptions(options core.ReportOptions) {
+	m.reportOptions = options
+}
+
 // SetLogger sets the logger for the model
 func (m *MainModel) SetLogger(logger core.Logger) {
 	m.logger = logger
@@ -419,6 +594,17 @@ func (m *MainModel) SetLogger(logger core.Logger) {
 
 // SetRootFolder sets the root folder for the model
 func (m *MainModel) SetRootFolder(folder *FolderItemWrapper) {
+	for _, mount := range m.mounts {
+		if !mount.Online {
+			if folder.markers == nil {
+				folder.markers = map[string]string{}
+			}
+			folder.markers[mount.Label] = "offline"
+		}
+	}
+	folder.redundant = func(path string) bool {
+		return m.similarityChecker.IsRedundant(path)
+	}
 	m.rootFolder = folder
 	m.treeView.AddItem(m.rootFolder)
 }
@@ -433,7 +619,99 @@ func (m *MainModel) GetStorage() core.Storage {
 	return m.storage
 }
 
+// mainView returns the view shown on the right side, under the dialogs
+func (m *MainModel) mainView() tea.Model {
+	switch m.rightView {
+	case SameFolderView:
+		return m.sameFolderView
+	case RankedPairsView:
+		return m.rankedPairsView
+	case ClusterView:
+		return m.clusterView
+	}
+	return m.fileListView
+}
+
+// reloadFilteredTree applies the changed metric or thresholds to the filtered tree
+func (m *MainModel) reloadFilteredTree() {
+	if !m.treeView.HasFilter() {
+		return
+	}
+	highlightedItem := m.treeView.HighLightedItem()
+	m.treeView.SetItems([]tree.Item{m.rootFolder})
+	if highlightedItem != nil {
+		m.treeView.MoveToItem(highlightedItem)
+	}
+}
+
+// AdjustThreshold changes a threshold of the reported folder pairs by key:
+// +/- the minimum percentage by 10, ]/[ doubles or halves the minimum files,
+// }/{ multiplies or divides the minimum size by 10 and b toggles both sides
+func (m *MainModel) AdjustThreshold(key string) {
+	threshold := &m.similarityChecker.Threshold
+	switch key {
+	case "+":
+		threshold.MinPercentage = min(100, threshold.MinPercentage+10)
+	case "-":
+		threshold.MinPercentage = max(0, threshold.MinPercentage-10)
+	case "]":
+		threshold.MinFiles = max(1, threshold.MinFiles*2)
+	case "[":
+		threshold.MinFiles /= 2
+	case "}":
+		threshold.MinSize = max(1024*1024, threshold.MinSize*10)
+	case "{":
+		threshold.MinSize /= 10
+		if threshold.MinSize < 1024*1024 {
+			threshold.MinSize = 0
+		}
+	case "b":
+		threshold.BothSides = !threshold.BothSides
+	}
+	m.logView.Info("Thresholds: " + threshold.String())
+	m.reloadFilteredTree()
+}
+
+// ShowSameFolderDuplicates lists the duplicate files inside the folder at path to choose the copies to keep
+func (m *MainModel) ShowSameFolderDuplicates(path string) {
+	groups := m.similarityChecker.GetSameFolderDuplicates(path)
+	if len(groups) == 0 {
+		m.logView.Info("No duplicate files inside " + path)
+		return
+	}
+	m.sameFolderView.SetGroups(path, groups)
+	m.rightView = SameFolderView
+	m.focus = ListFocus
+}
+
+// ShowRankedPairs lists the most redundant folder pairs of the whole tree, ranked by ranking
+func (m *MainModel) ShowRankedPairs(ranking core.PairRanking) {
+	pairs := m.similarityChecker.GetRankedFolderPairs(ranking, maxRankedPairs)
+	m.rankedPairsView.SetPairs(pairs, ranking, m.similarityChecker.Metric)
+	m.rightView = RankedPairsView
+	m.focus = ListFocus
+}
+
+// ShowClusters lists the clusters of similar folders to keep one folder of each
+func (m *MainModel) ShowClusters() {
+	clusters := m.similarityChecker.GetFolderClusters(m.clusterPercentage())
+	if len(clusters) == 0 {
+		m.logView.Info("No clusters of similar folders")
+		return
+	}
+	m.clusterView.SetClusters(clusters, m.similarityChecker.Metric)
+	m.rightView = ClusterView
+	m.focus = ListFocus
+}
+
+// clusterPercentage returns the lowest similarity of the folders joined in a cluster,
+// raised to the minimum percentage of the thresholds
+func (m *MainModel) clusterPercentage() float64 {
+	return max(minClusterPercentage, m.similarityChecker.Threshold.MinPercentage)
+}
+
 func (m *MainModel) HandleTreeFolderSelected(selectedItem tree.Item) {
+	m.rightView = CompareView
 	if selectedItem != nil {
 		if folder, ok := selectedItem.(*FolderItemWrapper); ok {
 			childCount := len(folder.GetChildren())
@@ -447,13 +725,11 @@ func (m *MainModel) HandleTreeFolderSelected(selectedItem tree.Item) {
 				options := make([]string, len(groups))
 				for i, group := range groups {
 					targetPath := group[1].Path
-					f1Duplicated := group[0].DuplicateFileCount
-					f1Total := group[0].FileCount
-					f1Coverage := group[0].DuplicatedPercentage()
-					f2Duplicated := group[1].DuplicateFileCount
-					f2Total := group[1].FileCount
-					f2Coverage := group[1].DuplicatedPercentage()
-					options[i] = fmt.Sprintf("%s (F1: %d/%d %.1f%% | F2: %d/%d %.1f%%)", targetPath, f1Duplicated, f1Total, f1Coverage, f2Duplicated, f2Total, f2Coverage)
+					metric := m.similarityChecker.Metric
+					options[i] = fmt.Sprintf("%s (F1: %s | F2: %s)", targetPath, group[0].Coverage(metric), group[1].Coverage(metric))
+					if relation := m.similarityChecker.GetFolderRelation(group[0]); relation != core.PartialOverlap {
+						options[i] += " [" + relation.String() + "]"
+					}
 				}
 				m.selectListDialog.SetMessage(fmt.Sprintf("Target folder: %s, Select folder pair to compare: ", folder.Path))
 				m.selectListDialog.SetOptions(options)
@@ -497,18 +773,30 @@ func (m *MainModel) HandleApplyActions(msg comparelist.ActionApplyMsg) {
 	}
 
 	message := fmt.Sprintf("Apply following actions:\nMove %d files, delete %d files, replace %d files\nDelete  %d  Non-duplicate files, delete %d folders, move %d folders", moveCount, deleteCount, replaceCount, nonDuplicateDeleteCount, deleteFolderCount, moveFolderCount)
+	if len(m.mounts) > 0 {
+		if err := core.CheckMountedTasks(m.mounts, msg.Actions); err != nil {
+			m.logView.Error("Actions disabled: " + err.Error())
+			return
+		}
+	}
+
 	m.actionConfirmDialog.SetMessage(message)
-	m.overlay = overlay.New(m.actionConfirmDialog, m.fileListView, overlay.Center, overlay.Center, 0, 0)
+	m.overlay = overlay.New(m.actionConfirmDialog, m.mainView(), overlay.Center, overlay.Center, 0, 0)
 	m.focus = DialogFocus
 	m.pendingActions = msg.Actions
 }
 
 func (m *MainModel) OpenFileExplorer(path string) {
+	rootPath := m.rootPath
+	if mount := core.FindMount(m.mounts, path); mount != nil {
+		rootPath = mount.Root
+		path, _ = filepath.Rel(mount.Label, path)
+	}
 	switch runtime.GOOS {
 	case "windows":
 		exec.Command("explorer", path).Start()
 	case "darwin":
-		exec.Command("open", filepath.Join(m.rootPath, path)).Start()
+		exec.Command("open", filepath.Join(rootPath, path)).Start()
 	case "linux":
 		exec.Command("xdg-open", path).Start()
 	default:
@@ -519,7 +807,9 @@ func (m *MainModel) OpenFileExplorer(path string) {
 func (m *MainModel) Refresh() {
 	currentNode := m.treeView.Selected()
 
-	m.similarityChecker.CalculateSimilarity(m.storage)
+	if err := m.similarityChecker.ApplyChanges(); err != nil {
+		m.logView.Error("Failed to update similarity: " + err.Error())
+	}
 	m.treeView.SetItems([]tree.Item{m.rootFolder})
 
 	if currentNode != nil {
@@ -528,4 +818,65 @@ func (m *MainModel) Refresh() {
 		}
 	}
 	m.fileListView.SetMergeFolderPair(nil)
+
+	switch m.rightView {
+	case SameFolderView:
+		path := m.sameFolderView.GetPath()
+		m.sameFolderView.SetGroups(path, m.similarityChecker.GetSameFolderDuplicates(path))
+	case RankedPairsView:
+		ranking := m.rankedPairsView.GetRanking()
+		m.rankedPairsView.SetPairs(m.similarityChecker.GetRankedFolderPairs(ranking, maxRankedPairs), ranking, m.similarityChecker.Metric)
+	case ClusterView:
+		m.clusterView.SetClusters(m.similarityChecker.GetFolderClusters(m.clusterPercentage()), m.similarityChecker.Metric)
+	}
+}
+
+// ShowStats writes the storage statistics with the top extensions and folders to the log view
+func (m *MainModel) ShowStats() {
+	stats, err := core.CalculateStats(m.storage)
+	if err != nil {
+		m.logView.Error(err.Error())
+		return
+	}
+	m.logView.Info(stats.Summary())
+	for _, breakdown := range stats.Extensions[:min(3, len(stats.Extensions))] {
+		m.logView.Info("Extension " + breakdown.String())
+	}
+	for _, breakdown := range stats.Folders[:min(3, len(stats.Folders))] {
+		m.logView.Info("Folder " + breakdown.String())
+	}
+}
+
+// VerifyFiles compares the stored files with the file system and optionally removes the stale ones
+func (m *MainModel) VerifyFiles(prune bool) {
+	if len(m.mounts) > 0 {
+		m.logView.Error("Verifying merged databases is not supported, use the verify command on each database")
+		return
+	}
+	verifier := core.Verifier{
+		Path:    m.rootPath,
+		Storage: m.storage,
+		Hasher:  m.databaseHeader.Hasher,
+	}
+	result, err := verifier.Verify()
+	if err != nil {
+		m.logView.Error(err.Error())
+		return
+	}
+	m.logView.Info("Verify: " + result.Summary())
+	for _, file := range result.Missing[:min(3, len(result.Missing))] {
+		m.logView.Info("Missing " + file.Path)
+	}
+	for _, change := range result.Changed[:min(3, len(result.Changed))] {
+		m.logView.Info("Changed " + change.Old.Path)
+	}
+
+	if prune && result.Stale() > 0 {
+		if err := result.Prune(m.storage); err != nil {
+			m.logView.Error(err.Error())
+			return
+		}
+		m.logView.Info(fmt.Sprintf("Pruned %d stale files", result.Stale()))
+		m.Refresh()
+	}
 }
@@ -0,0 +1,194 @@
+package pairlist
+
+import (
+	"fmt"
+	"folder-similarity/core"
+	"strconv"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/table"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	TitleStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("229")).
+			Background(lipgloss.Color("129"))
+
+	RankingNames = []string{"reclaimable bytes", "percentage"}
+)
+
+// Model lists the folder pairs of the whole tree, most redundant first.
+type Model struct {
+	ready   bool
+	pairs   [][2]*core.FolderSimilarity
+	ranking core.PairRanking
+	metric  core.SimilarityMetric
+	table   table.Model
+	help    help.Model
+	width   int
+	height  int
+	keyMap  KeyMap
+}
+
+type KeyMap struct {
+	Open  key.Binding
+	Rank  key.Binding
+	Close key.Binding
+}
+
+func (k KeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{
+		k.Open,
+		k.Rank,
+		k.Close,
+	}
+}
+
+func (k KeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Open, k.Rank, k.Close},
+	}
+}
+
+func DefaultKeyMap() KeyMap {
+	return KeyMap{
+		Open: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "compare"),
+		),
+		Rank: key.NewBinding(
+			key.WithKeys("p"),
+			key.WithHelp("p", "rank by bytes/percentage"),
+		),
+		Close: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "close"),
+		),
+	}
+}
+
+// SelectMsg is sent when a pair is chosen to be compared.
+type SelectMsg struct {
+	Pair [2]*core.FolderSimilarity
+}
+
+// RankMsg is sent to list the pairs again with another ranking.
+type RankMsg struct {
+	Ranking core.PairRanking
+}
+
+// CloseMsg is sent when the list is closed.
+type CloseMsg struct{}
+
+func (m Model) Init() tea.Cmd {
+	return nil
+}
+
+// SetPairs shows the pairs ranked by ranking, with their coverage measured by metric.
+func (m *Model) SetPairs(pairs [][2]*core.FolderSimilarity, ranking core.PairRanking, metric core.SimilarityMetric) {
+	m.pairs = pairs
+	m.ranking = ranking
+	m.metric = metric
+	m.table.SetCursor(min(m.table.Cursor(), max(0, len(pairs)-1)))
+	m.updateItems()
+}
+
+// GetRanking returns the ranking of the pairs shown.
+func (m *Model) GetRanking() core.PairRanking {
+	return m.ranking
+}
+
+func (m *Model) updateItems() {
+	rows := []table.Row{}
+	for i, pair := range m.pairs {
+		rows = append(rows, table.Row{
+			strconv.Itoa(i + 1),
+			core.FormatFileSize(core.ReclaimableSize(pair)),
+			pair[0].Path,
+			pair[0].Coverage(m.metric),
+			pair[1].Path,
+			pair[1].Coverage(m.metric),
+		})
+	}
+	m.table.SetRows(rows)
+}
+
+func (m *Model) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	m.table.SetWidth(width)
+	m.table.SetHeight(height)
+	m.ready = true
+
+	columns := m.table.Columns()
+	pathWidth := max(15, (width-74)/2)
+	columns[2].Width = pathWidth
+	columns[4].Width = pathWidth
+	m.table.SetColumns(columns)
+}
+
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	m.table, _ = m.table.Update(msg)
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keyMap.Close):
+			return &m, func() tea.Msg { return CloseMsg{} }
+		case key.Matches(msg, m.keyMap.Rank):
+			ranking := (m.ranking + 1) % core.PairRanking(len(RankingNames))
+			return &m, func() tea.Msg { return RankMsg{Ranking: ranking} }
+		case key.Matches(msg, m.keyMap.Open) && len(m.pairs) > 0:
+			pair := m.pairs[m.table.Cursor()]
+			return &m, func() tea.Msg { return SelectMsg{Pair: pair} }
+		}
+	}
+	return &m, nil
+}
+
+func (m Model) View() string {
+	if !m.ready || m.width == 0 || m.height == 0 {
+		return "Loading..."
+	}
+
+	helpView := m.help.View(m.keyMap)
+	title := TitleStyle.Width(m.width).Render(fmt.Sprintf("%d folder pairs by %s", len(m.pairs), RankingNames[m.ranking]))
+
+	m.table.SetHeight(m.height - lipgloss.Height(title) - lipgloss.Height(helpView))
+	return lipgloss.JoinVertical(lipgloss.Left, title, m.table.View(), helpView)
+}
+
+func New() *Model {
+	columns := []table.Column{
+		{Title: "No.", Width: 4},
+		{Title: "Reclaim", Width: 10},
+		{Title: "Folder A", Width: 15},
+		{Title: "Coverage", Width: 24},
+		{Title: "Folder B", Width: 15},
+		{Title: "Coverage", Width: 24},
+	}
+
+	s := table.DefaultStyles()
+	s.Header = s.Header.
+		BorderStyle(lipgloss.NormalBorder()).
+		BorderForeground(lipgloss.Color("240")).
+		BorderBottom(true).
+		Bold(false)
+	s.Selected = s.Selected.
+		Foreground(lipgloss.Color("229")).
+		Background(lipgloss.Color("57")).
+		Bold(false)
+
+	return &Model{
+		keyMap: DefaultKeyMap(),
+		table: table.New(
+			table.WithColumns(columns),
+			table.WithFocused(true),
+			table.WithStyles(s),
+		),
+		help: help.New(),
+	}
+}
@@ -10,6 +10,10 @@ type FolderItemWrapper struct {
 	*core.Folder
 	childrenItem []tree.Item
 	parentItem   tree.Item
+	// markers are shown after the name of the folders by path, like offline databases
+	markers map[string]string
+	// redundant reports the folders with a copy of every file elsewhere, if set
+	redundant func(path string) bool
 }
 
 // GetChildren implements tree.Item.
@@ -19,13 +23,22 @@ func (f *FolderItemWrapper) GetChildren() []tree.Item {
 	}
 
 	for _, folder := range f.GetFolders() {
-		f.childrenItem = append(f.childrenItem, &FolderItemWrapper{Folder: folder, parentItem: f})
+		f.childrenItem = append(f.childrenItem, &FolderItemWrapper{Folder: folder, parentItem: f, markers: f.markers, redundant: f.redundant})
 	}
 	return f.childrenItem
 }
 
 // GetName implements tree.Item.
 func (f *FolderItemWrapper) GetName() string {
+	if marker, ok := f.markers[f.Path]; ok {
+		return f.Name + " [" + marker + "]"
+	}
+	if f.redundant != nil && f.redundant(f.Path) {
+		return f.Name + " [redundant]"
+	}
+	if f.Info != nil && f.GetFileCount() == 0 {
+		return f.Name + " [empty]"
+	}
 	return f.Name
 }
 
@@ -38,7 +51,7 @@ func (f *FolderItemWrapper) Parent() tree.Item {
 		return f.parentItem
 	}
 
-	return &FolderItemWrapper{Folder: f.Folder.Parent, parentItem: f}
+	return &FolderItemWrapper{Folder: f.Folder.Parent, parentItem: f, markers: f.markers, redundant: f.redundant}
 }
 
 var _ tree.Item = &FolderItemWrapper{}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"folder-similarity/core"
+	"os"
+)
+
+// runVerify compares a saved database with the file system and optionally prunes stale entries.
+func runVerify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	rehash := flags.Bool("rehash", false, "hash files with unchanged size and modification time to detect changed content")
+	prune := flags.Bool("prune", false, "remove missing and changed files from the database")
+	remapValue := flags.String("remap", "", "relocate the root recorded in the database, given as old=new")
+	jsonOutput := flags.Bool("json", false, "print the result as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dedup verify [-rehash] [-prune] [-remap old=new] [-json] <db> [root]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		return fmt.Errorf("a database file is required")
+	}
+	dbPath, rootPath := flags.Arg(0), flags.Arg(1)
+
+	remaps := []core.RootRemap{}
+	if *remapValue != "" {
+		remap, err := core.ParseRootRemap(*remapValue)
+		if err != nil {
+			return err
+		}
+		if remap.Label != "" {
+			return fmt.Errorf("the remap of %s needs merged databases", remap.Label)
+		}
+		remaps = append(remaps, remap)
+	}
+
+	storage := core.NewMemoryStorage()
+	header, err := core.LoadDatabase(dbPath, storage, rootPath, "", remaps...)
+	if err != nil {
+		return err
+	}
+	if rootPath == "" {
+		if len(header.Roots) == 0 {
+			return fmt.Errorf("%s does not record its root, give the root path", dbPath)
+		}
+		rootPath = header.Roots[0]
+	}
+
+	verifier := core.Verifier{
+		Path:    rootPath,
+		Storage: storage,
+		Hasher:  header.Hasher,
+		Rehash:  *rehash,
+	}
+	result, err := verifier.Verify()
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+	} else if err := result.WriteText(os.Stdout); err != nil {
+		return err
+	}
+
+	if *prune && result.Stale() > 0 {
+		if err := result.Prune(storage); err != nil {
+			return err
+		}
+		if err := core.SaveDatabase(dbPath, storage, *header); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Pruned %d stale files from %s\n", result.Stale(), dbPath)
+	}
+	return nil
+}